@@ -0,0 +1,353 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WithAggregation 添加一个命名聚合，可以多次调用，聚合使用elastic提供的构造器，例如
+//
+//	es.WithAggregation("by_day", elastic.NewDateHistogramAggregation().Field("ts").CalendarInterval("day").
+//		SubAggregation("users", elastic.NewCardinalityAggregation().Field("uid")))
+func WithAggregation(name string, aggregation elastic.Aggregation) QueryOption {
+	return func(opt *queryOption) {
+		if opt.Aggregations == nil {
+			opt.Aggregations = make(map[string]elastic.Aggregation)
+		}
+		opt.Aggregations[name] = aggregation
+	}
+}
+
+// WithAggregationOnly 只返回聚合结果，忽略from、size，不返回文档
+func WithAggregationOnly(aggregationOnly bool) QueryOption {
+	return func(opt *queryOption) {
+		opt.AggregationOnly = aggregationOnly
+	}
+}
+
+// AggResult 按名称索引的聚合结果
+type AggResult map[string]*AggValue
+
+// AggValue 单个聚合的结果：
+// 指标聚合(cardinality、avg、sum等)取Value；percentiles、stats取Values；
+// 多桶聚合(terms、date_histogram、range等)取Buckets；单桶聚合(nested、filter)取DocCount和Aggs
+type AggValue struct {
+	Value    *float64
+	Values   map[string]float64
+	DocCount int64
+	Buckets  []*AggBucket
+	Aggs     AggResult
+	Raw      json.RawMessage
+}
+
+type AggBucket struct {
+	Key         interface{} //数字类型为json.Number，keyed的range、filters为桶名称
+	KeyAsString string
+	DocCount    int64
+	From        *float64 //range聚合
+	To          *float64
+	Aggs        AggResult
+}
+
+// KeyString 桶的key，优先使用key_as_string
+func (b *AggBucket) KeyString() string {
+	if len(b.KeyAsString) > 0 {
+		return b.KeyAsString
+	}
+	return fmt.Sprint(b.Key)
+}
+
+// Bucket 按KeyString查找桶
+func (v *AggValue) Bucket(key string) *AggBucket {
+	for _, bucket := range v.Buckets {
+		if bucket.KeyString() == key {
+			return bucket
+		}
+	}
+	return nil
+}
+
+// Float 将Value转为float64，没有值时返回0
+func (v *AggValue) Float() float64 {
+	if v == nil || v.Value == nil {
+		return 0
+	}
+	return *v.Value
+}
+
+// 聚合结果中不是子聚合的字段
+var aggReservedKeys = map[string]bool{
+	"key": true, "key_as_string": true, "doc_count": true, "from": true, "to": true, "from_as_string": true,
+	"to_as_string": true, "buckets": true, "value": true, "value_as_string": true, "values": true, "meta": true,
+	"doc_count_error_upper_bound": true, "sum_other_doc_count": true, "after_key": true, "interval": true,
+}
+
+// DecodeAggregations 将查询结果中的全部聚合解码为桶树
+func DecodeAggregations(res *elastic.SearchResult) (AggResult, error) {
+	if res == nil || len(res.Aggregations) == 0 {
+		return AggResult{}, nil
+	}
+	return decodeAggResult(res.Aggregations)
+}
+
+// DecodeAggregation 将单个聚合的原始结果解码为自定义类型T，聚合不存在时返回false
+func DecodeAggregation[T any](res *elastic.SearchResult, name string) (T, bool, error) {
+	var v T
+	if res == nil {
+		return v, false, nil
+	}
+	raw, ok := res.Aggregations[name]
+	if !ok {
+		return v, false, nil
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return v, true, fmt.Errorf("decode aggregation %s: %w", name, err)
+	}
+	return v, true, nil
+}
+
+// Aggregate 只执行聚合，等同于带WithAggregationOnly的Query
+func Aggregate(ctx context.Context, s Searcher, indexName string, routes []string, query elastic.Query, options ...QueryOption) (AggResult, error) {
+	options = append(options[:len(options):len(options)], WithAggregationOnly(true))
+	res, err := s.Query(ctx, indexName, routes, query, 0, 0, options...)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAggregations(res)
+}
+
+func decodeAggResult(aggs map[string]json.RawMessage) (AggResult, error) {
+	result := make(AggResult, len(aggs))
+	for name, raw := range aggs {
+		fields, err := decodeObject(raw)
+		if err != nil {
+			return nil, fmt.Errorf("decode aggregation %s: %w", name, err)
+		}
+		value, err := decodeAggValue(fields)
+		if err != nil {
+			return nil, fmt.Errorf("decode aggregation %s: %w", name, err)
+		}
+		value.Raw = raw
+		result[name] = value
+	}
+	return result, nil
+}
+
+func decodeAggValue(fields map[string]json.RawMessage) (*AggValue, error) {
+	v := &AggValue{}
+	var err error
+	if raw, ok := fields["value"]; ok {
+		if v.Value, err = decodeFloat(raw); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := fields["values"]; ok {
+		if v.Values, err = decodePercentiles(raw); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := fields["doc_count"]; ok {
+		if err = json.Unmarshal(raw, &v.DocCount); err != nil {
+			return nil, err
+		}
+	}
+	if raw, ok := fields["buckets"]; ok {
+		if v.Buckets, err = decodeBuckets(raw); err != nil {
+			return nil, err
+		}
+	}
+	if v.Aggs, err = decodeSubAggs(fields); err != nil {
+		return nil, err
+	}
+	//stats等聚合直接返回多个数值字段
+	for key, raw := range fields {
+		if aggReservedKeys[key] || strings.HasSuffix(key, "_as_string") || bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			continue
+		}
+		f, err := decodeFloat(raw)
+		if err != nil || f == nil {
+			continue
+		}
+		if v.Values == nil {
+			v.Values = make(map[string]float64)
+		}
+		v.Values[key] = *f
+	}
+	return v, nil
+}
+
+func decodeBuckets(raw json.RawMessage) ([]*AggBucket, error) {
+	raw = bytes.TrimSpace(raw)
+	//keyed=true的range以及filters聚合返回对象，key为桶名称
+	if bytes.HasPrefix(raw, []byte("{")) {
+		keyed := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(raw, &keyed); err != nil {
+			return nil, err
+		}
+		buckets := make([]*AggBucket, 0, len(keyed))
+		for _, key := range sortedKeys(keyed) {
+			bucket, err := decodeBucket(keyed[key])
+			if err != nil {
+				return nil, err
+			}
+			if bucket.Key == nil {
+				bucket.Key = key
+			}
+			buckets = append(buckets, bucket)
+		}
+		return buckets, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return nil, err
+	}
+	buckets := make([]*AggBucket, 0, len(list))
+	for _, item := range list {
+		bucket, err := decodeBucket(item)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func decodeBucket(raw json.RawMessage) (*AggBucket, error) {
+	fields, err := decodeObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	bucket := &AggBucket{}
+	if key, ok := fields["key"]; ok {
+		decoder := json.NewDecoder(bytes.NewReader(key))
+		decoder.UseNumber()
+		if err = decoder.Decode(&bucket.Key); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := fields["key_as_string"]; ok {
+		if err = json.Unmarshal(key, &bucket.KeyAsString); err != nil {
+			return nil, err
+		}
+	}
+	if count, ok := fields["doc_count"]; ok {
+		if err = json.Unmarshal(count, &bucket.DocCount); err != nil {
+			return nil, err
+		}
+	}
+	if from, ok := fields["from"]; ok {
+		if bucket.From, err = decodeFloat(from); err != nil {
+			return nil, err
+		}
+	}
+	if to, ok := fields["to"]; ok {
+		if bucket.To, err = decodeFloat(to); err != nil {
+			return nil, err
+		}
+	}
+	if bucket.Aggs, err = decodeSubAggs(fields); err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
+
+// decodeSubAggs 非保留字段且值为对象的都是子聚合
+func decodeSubAggs(fields map[string]json.RawMessage) (AggResult, error) {
+	sub := make(map[string]json.RawMessage)
+	for key, raw := range fields {
+		if aggReservedKeys[key] || !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+			continue
+		}
+		sub[key] = raw
+	}
+	if len(sub) == 0 {
+		return nil, nil
+	}
+	return decodeAggResult(sub)
+}
+
+// decodePercentiles 兼容keyed(对象)和非keyed(数组)两种返回格式，非keyed的key按ES返回的字面值使用，与keyed格式一致(例如"99.0"、"99.95")
+func decodePercentiles(raw json.RawMessage) (map[string]float64, error) {
+	values := make(map[string]float64)
+	raw = bytes.TrimSpace(raw)
+	if bytes.HasPrefix(raw, []byte("[")) {
+		var list []struct {
+			Key   json.Number     `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+		for _, item := range list {
+			f, err := decodeFloat(item.Value)
+			if err != nil {
+				return nil, err
+			}
+			if f != nil {
+				values[item.Key.String()] = *f
+			}
+		}
+		return values, nil
+	}
+	keyed := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &keyed); err != nil {
+		return nil, err
+	}
+	for key, value := range keyed {
+		if strings.HasSuffix(key, "_as_string") {
+			continue
+		}
+		f, err := decodeFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			values[key] = *f
+		}
+	}
+	return values, nil
+}
+
+// decodeFloat null返回nil，ES对空集合可能返回"NaN"等字符串
+func decodeFloat(raw json.RawMessage) (*float64, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	var f float64
+	if err := json.Unmarshal(raw, &f); err == nil {
+		return &f, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func decodeObject(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
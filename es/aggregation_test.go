@@ -0,0 +1,104 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testAggResponse = `{"took":2,"hits":{"total":{"value":20,"relation":"eq"},"hits":[]},"aggregations":{
+	"by_day":{"buckets":[
+		{"key_as_string":"2024-01-01","key":1704067200000,"doc_count":12,
+		 "users":{"value":5},
+		 "latency":{"values":{"50.0":12.5,"99.0":80,"99.0_as_string":"80ms"}}},
+		{"key_as_string":"2024-01-02","key":1704153600000,"doc_count":8,"users":{"value":3},"latency":{"values":{"50.0":null}}}]},
+	"by_code":{"doc_count_error_upper_bound":0,"sum_other_doc_count":0,"buckets":[{"key":200,"doc_count":18},{"key":500,"doc_count":2}]},
+	"sizes":{"buckets":{"small":{"to":100.0,"doc_count":4},"large":{"from":100.0,"doc_count":16}}},
+	"comments":{"doc_count":40,"authors":{"buckets":[{"key":"bob","doc_count":30}]}},
+	"took_stats":{"count":20,"min":1,"max":90,"avg":null,"sum":300},
+	"empty_avg":{"value":null},
+	"latency_list":{"values":[{"key":50.0,"value":12.5},{"key":99.95,"value":95},{"key":100.0,"value":120}]}}}`
+
+func TestDecodeAggregations(t *testing.T) {
+	res := &elastic.SearchResult{}
+	if err := json.Unmarshal([]byte(testAggResponse), res); err != nil {
+		t.Fatal(err)
+	}
+	aggs, err := DecodeAggregations(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDay := aggs["by_day"]
+	if len(byDay.Buckets) != 2 || byDay.Buckets[0].Key.(json.Number).String() != "1704067200000" {
+		t.Fatalf("unexpected date histogram %+v", byDay.Buckets)
+	}
+	day := byDay.Bucket("2024-01-01")
+	if day == nil || day.DocCount != 12 || day.Aggs["users"].Float() != 5 {
+		t.Fatalf("unexpected bucket %+v", day)
+	}
+	if latency := day.Aggs["latency"].Values; len(latency) != 2 || latency["50.0"] != 12.5 || latency["99.0"] != 80 {
+		t.Fatalf("unexpected percentiles %v", latency)
+	}
+	if code := aggs["by_code"].Bucket("500"); code == nil || code.DocCount != 2 {
+		t.Fatalf("unexpected terms bucket %+v", aggs["by_code"].Buckets)
+	}
+	sizes := aggs["sizes"]
+	if sizes.Buckets[0].Key != "large" || *sizes.Buckets[0].From != 100 || sizes.Bucket("small").DocCount != 4 {
+		t.Fatalf("unexpected keyed range buckets %+v", sizes.Buckets)
+	}
+	comments := aggs["comments"]
+	if comments.DocCount != 40 || comments.Aggs["authors"].Bucket("bob").DocCount != 30 {
+		t.Fatalf("unexpected nested aggregation %+v", comments)
+	}
+	if stats := aggs["took_stats"].Values; stats["sum"] != 300 || stats["count"] != 20 {
+		t.Fatalf("unexpected stats %v", stats)
+	}
+	if aggs["empty_avg"].Value != nil {
+		t.Fatal("expected nil value for empty avg")
+	}
+	//非keyed格式的key与keyed格式一致，99.95不能与100.0冲突
+	if list := aggs["latency_list"].Values; len(list) != 3 || list["50.0"] != 12.5 || list["99.95"] != 95 || list["100.0"] != 120 {
+		t.Fatalf("unexpected non-keyed percentiles %v", list)
+	}
+
+	type terms struct {
+		Buckets []struct {
+			Key      int   `json:"key"`
+			DocCount int64 `json:"doc_count"`
+		} `json:"buckets"`
+	}
+	custom, found, err := DecodeAggregation[terms](res, "by_code")
+	if err != nil || !found || custom.Buckets[1].Key != 500 {
+		t.Fatalf("unexpected custom decode %+v %v %v", custom, found, err)
+	}
+}
+
+func TestAggregateOnly(t *testing.T) {
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Write([]byte(testAggResponse))
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	aggs, err := Aggregate(context.Background(), c, "idx", nil, nil,
+		WithAggregation("by_code", elastic.NewTermsAggregation().Field("code")),
+		WithAggregation("comments", elastic.NewNestedAggregation().Path("comments").
+			SubAggregation("authors", elastic.NewTermsAggregation().Field("comments.author"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body["size"] != float64(0) || body["aggregations"].(map[string]interface{})["comments"] == nil {
+		t.Fatalf("unexpected request body %v", body)
+	}
+	if aggs["by_code"].Bucket("200").DocCount != 18 {
+		t.Fatalf("unexpected result %+v", aggs["by_code"])
+	}
+}
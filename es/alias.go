@@ -0,0 +1,223 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+type aliasOption struct {
+	Filter        elastic.Query
+	Routing       string
+	IndexRouting  string
+	SearchRouting []string
+	IsWriteIndex  *bool
+}
+
+type AliasOption func(opt *aliasOption)
+
+// WithAliasFilter 过滤别名，通过别名查询时只能看到满足filter的文档
+func WithAliasFilter(filter elastic.Query) AliasOption {
+	return func(opt *aliasOption) {
+		opt.Filter = filter
+	}
+}
+
+// WithAliasRouting 路由别名，同时作用于写入和查询
+func WithAliasRouting(routing string) AliasOption {
+	return func(opt *aliasOption) {
+		opt.Routing = routing
+	}
+}
+
+func WithAliasIndexRouting(routing string) AliasOption {
+	return func(opt *aliasOption) {
+		opt.IndexRouting = routing
+	}
+}
+
+func WithAliasSearchRouting(routing ...string) AliasOption {
+	return func(opt *aliasOption) {
+		opt.SearchRouting = routing
+	}
+}
+
+// WithWriteIndex 标记该索引为别名的写索引，别名指向多个索引时写入只会落到写索引
+func WithWriteIndex(isWriteIndex bool) AliasOption {
+	return func(opt *aliasOption) {
+		opt.IsWriteIndex = &isWriteIndex
+	}
+}
+
+func newAliasAddAction(alias, index string, options []AliasOption) *elastic.AliasAddAction {
+	opt := &aliasOption{}
+	for _, f := range options {
+		if f != nil {
+			f(opt)
+		}
+	}
+	action := elastic.NewAliasAddAction(alias).Index(index)
+	if opt.Filter != nil {
+		action.Filter(opt.Filter)
+	}
+	if len(opt.Routing) > 0 {
+		action.Routing(opt.Routing)
+	}
+	if len(opt.IndexRouting) > 0 {
+		action.IndexRouting(opt.IndexRouting)
+	}
+	if len(opt.SearchRouting) > 0 {
+		action.SearchRouting(opt.SearchRouting...)
+	}
+	if opt.IsWriteIndex != nil {
+		action.IsWriteIndex(*opt.IsWriteIndex)
+	}
+	return action
+}
+
+func (c *Client) AddAlias(ctx context.Context, indexName, alias string, options ...AliasOption) error {
+	_, err := c.Client.Alias().Action(newAliasAddAction(alias, indexName, options)).Do(ctx)
+	if err != nil {
+		return err
+	}
+	c.CacheIndices.Store(alias, true)
+	return nil
+}
+
+func (c *Client) RemoveAlias(ctx context.Context, indexName, alias string) error {
+	_, err := c.Client.Alias().Action(elastic.NewAliasRemoveAction(alias).Index(indexName)).Do(ctx)
+	//别名可能还指向其他索引，删除缓存，由下一次IndexExists重新检查
+	c.CacheIndices.Delete(alias)
+	return err
+}
+
+// SwapAlias 在一次_aliases请求中将别名从当前指向的全部索引移到indexName，切换过程中别名始终可用
+func (c *Client) SwapAlias(ctx context.Context, alias, indexName string, options ...AliasOption) error {
+	current, err := c.AliasIndices(ctx, alias)
+	if err != nil {
+		return err
+	}
+	aliasService := c.Client.Alias()
+	for _, index := range current {
+		if index != indexName {
+			aliasService.Action(elastic.NewAliasRemoveAction(alias).Index(index))
+		}
+	}
+	aliasService.Action(newAliasAddAction(alias, indexName, options))
+	if _, err = aliasService.Do(ctx); err != nil {
+		return err
+	}
+	c.CacheIndices.Store(alias, true)
+	return nil
+}
+
+// AliasIndices 返回别名指向的索引，别名不存在时返回空
+func (c *Client) AliasIndices(ctx context.Context, alias string) ([]string, error) {
+	definitions, err := c.getAlias(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(definitions))
+	for index := range definitions {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// WriteIndex 返回别名的写索引，别名只指向一个索引且没有显式设置写索引时返回该索引
+func (c *Client) WriteIndex(ctx context.Context, alias string) (string, error) {
+	definitions, err := c.getAlias(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	for index, definition := range definitions {
+		if definition.IsWriteIndex != nil && *definition.IsWriteIndex {
+			return index, nil
+		}
+	}
+	if len(definitions) == 1 {
+		for index := range definitions {
+			return index, nil
+		}
+	}
+	return "", fmt.Errorf("es: alias %s has no write index", alias)
+}
+
+// SetWriteIndex 将indexName设为别名的写索引，别名指向的其他索引取消写索引标记，别名原有的filter和routing保持不变
+func (c *Client) SetWriteIndex(ctx context.Context, alias, indexName string) error {
+	definitions, err := c.getAlias(ctx, alias)
+	if err != nil {
+		return err
+	}
+	aliasService := c.Client.Alias()
+	for index, definition := range definitions {
+		if index != indexName {
+			aliasService.Action(definition.addAction(alias, index).IsWriteIndex(false))
+		}
+	}
+	if definition, ok := definitions[indexName]; ok {
+		aliasService.Action(definition.addAction(alias, indexName).IsWriteIndex(true))
+	} else {
+		aliasService.Action(elastic.NewAliasAddAction(alias).Index(indexName).IsWriteIndex(true))
+	}
+	if _, err = aliasService.Do(ctx); err != nil {
+		return err
+	}
+	c.CacheIndices.Store(alias, true)
+	return nil
+}
+
+// aliasDefinition GET /_alias返回的别名配置
+type aliasDefinition struct {
+	Filter        json.RawMessage `json:"filter,omitempty"`
+	IndexRouting  string          `json:"index_routing,omitempty"`
+	SearchRouting string          `json:"search_routing,omitempty"`
+	IsWriteIndex  *bool           `json:"is_write_index,omitempty"`
+}
+
+// addAction 按原有配置重新添加别名
+func (d *aliasDefinition) addAction(alias, index string) *elastic.AliasAddAction {
+	action := elastic.NewAliasAddAction(alias).Index(index)
+	if len(d.Filter) > 0 {
+		action.Filter(elastic.NewRawStringQuery(string(d.Filter)))
+	}
+	if len(d.IndexRouting) > 0 {
+		action.IndexRouting(d.IndexRouting)
+	}
+	if len(d.SearchRouting) > 0 {
+		action.SearchRouting(strings.Split(d.SearchRouting, ",")...)
+	}
+	return action
+}
+
+// getAlias 返回别名在每个索引上的配置，别名不存在时返回空
+func (c *Client) getAlias(ctx context.Context, alias string) (map[string]*aliasDefinition, error) {
+	res, err := c.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_alias/" + url.PathEscape(alias),
+	})
+	if elastic.IsNotFound(err) {
+		return map[string]*aliasDefinition{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	indices := make(map[string]struct {
+		Aliases map[string]*aliasDefinition `json:"aliases"`
+	})
+	if err = json.Unmarshal(res.Body, &indices); err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]*aliasDefinition, len(indices))
+	for index, data := range indices {
+		if definition, ok := data.Aliases[alias]; ok {
+			definitions[index] = definition
+		}
+	}
+	return definitions, nil
+}
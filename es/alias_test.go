@@ -0,0 +1,134 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAliasServer 在内存中维护别名，支持_aliases、GET _alias和HEAD index
+type fakeAliasServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	aliases map[string]map[string]map[string]interface{} //alias -> index -> definition
+	posts   int
+	heads   int
+}
+
+func newFakeAliasServer(t *testing.T) *fakeAliasServer {
+	s := &fakeAliasServer{aliases: make(map[string]map[string]map[string]interface{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/_aliases":
+			s.posts++
+			req := struct {
+				Actions []map[string]map[string]interface{} `json:"actions"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&req)
+			for _, action := range req.Actions {
+				for typ, body := range action {
+					alias := body["alias"].(string)
+					index := body["index"].(string)
+					if typ == "remove" {
+						delete(s.aliases[alias], index)
+						continue
+					}
+					if s.aliases[alias] == nil {
+						s.aliases[alias] = make(map[string]map[string]interface{})
+					}
+					delete(body, "alias")
+					delete(body, "index")
+					if routing, ok := body["routing"]; ok {
+						body["index_routing"], body["search_routing"] = routing, routing
+						delete(body, "routing")
+					}
+					s.aliases[alias][index] = body
+				}
+			}
+			w.Write([]byte(`{"acknowledged":true}`))
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/_alias/"):
+			alias := strings.TrimPrefix(r.URL.Path, "/_alias/")
+			if len(s.aliases[alias]) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"alias [` + alias + `] missing","status":404}`))
+				return
+			}
+			res := make(map[string]interface{})
+			for index, definition := range s.aliases[alias] {
+				res[index] = map[string]interface{}{"aliases": map[string]interface{}{alias: definition}}
+			}
+			json.NewEncoder(w).Encode(res)
+		case r.Method == http.MethodHead && r.URL.Path != "/":
+			s.heads++
+			if len(s.aliases[strings.TrimPrefix(r.URL.Path, "/")]) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeAliasServer) definition(alias, index string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aliases[alias][index]
+}
+
+func TestAliasSwap(t *testing.T) {
+	srv := newFakeAliasServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	if exists, err := c.IndexExists(ctx, "users", false); err != nil || exists || srv.heads != 1 {
+		t.Fatalf("expected uncached alias to be checked, got %v %v heads=%d", exists, err, srv.heads)
+	}
+	if err := c.AddAlias(ctx, "users-v1", "users", WithAliasFilter(elastic.NewTermQuery("tenant", "a")), WithAliasRouting("a")); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := c.IndexExists(ctx, "users", false); err != nil || !exists || srv.heads != 1 {
+		t.Fatalf("expected alias to be cached after add, got %v %v heads=%d", exists, err, srv.heads)
+	}
+
+	if err := c.SwapAlias(ctx, "users", "users-v2"); err != nil {
+		t.Fatal(err)
+	}
+	indices, err := c.AliasIndices(ctx, "users")
+	if err != nil || len(indices) != 1 || indices[0] != "users-v2" || srv.posts != 2 {
+		t.Fatalf("expected alias to point to users-v2 only, got %v %v posts=%d", indices, err, srv.posts)
+	}
+
+	if err = c.AddAlias(ctx, "users-v1", "users", WithAliasFilter(elastic.NewTermQuery("tenant", "a")), WithAliasRouting("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.WriteIndex(ctx, "users"); err == nil {
+		t.Fatal("expected no write index for alias with two indices")
+	}
+	if err = c.SetWriteIndex(ctx, "users", "users-v2"); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := c.WriteIndex(ctx, "users"); err != nil || index != "users-v2" {
+		t.Fatalf("unexpected write index %s %v", index, err)
+	}
+	v1 := srv.definition("users", "users-v1")
+	if v1["filter"] == nil || v1["index_routing"] != "a" || v1["is_write_index"] != false {
+		t.Fatalf("expected filter and routing to be kept, got %v", v1)
+	}
+
+	if err = c.RemoveAlias(ctx, "users-v1", "users"); err != nil {
+		t.Fatal(err)
+	}
+	if indices, _ = c.AliasIndices(ctx, "missing"); len(indices) != 0 {
+		t.Fatalf("expected no indices for missing alias, got %v", indices)
+	}
+}
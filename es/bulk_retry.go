@@ -0,0 +1,213 @@
+package es
+
+import (
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// FailureClass bulk单个文档失败的类型
+type FailureClass string
+
+const (
+	FailureRetryable       FailureClass = "retryable"        //429 es_rejected_execution_exception、分片暂时不可用等
+	FailureMappingConflict FailureClass = "mapping_conflict" //mapper_parsing_exception等，重试也不会成功
+	FailureVersionConflict FailureClass = "version_conflict" //external版本号冲突，说明已有更新的版本，其他409(如create已存在)归为FailureOther
+	FailureOther           FailureClass = "other"
+)
+
+// FailureAction 对失败文档的处理方式
+type FailureAction int
+
+const (
+	FailureDeadLetter FailureAction = iota //写入死信
+	FailureRetry                           //退避后重新提交到BulkProcessor
+	FailureDrop                            //丢弃
+)
+
+// FailurePolicy 某一类失败的处理策略
+type FailurePolicy struct {
+	Action     FailureAction
+	MaxRetries int             //Action为FailureRetry时的最大重试次数
+	Backoff    elastic.Backoff //重试间隔，为空时使用100ms~10s的指数退避
+	Exhausted  FailureAction   //重试次数用尽后的处理方式，只能是FailureDeadLetter或FailureDrop
+}
+
+const (
+	defaultBulkMaxRetries     = 3
+	defaultBulkInitialBackoff = 100 * time.Millisecond
+	defaultBulkMaxBackoff     = 10 * time.Second
+)
+
+// DefaultFailurePolicies 可重试的失败退避重试3次后写入死信，版本冲突直接丢弃，其余写入死信
+func DefaultFailurePolicies() map[FailureClass]*FailurePolicy {
+	return map[FailureClass]*FailurePolicy{
+		FailureRetryable:       {Action: FailureRetry, MaxRetries: defaultBulkMaxRetries, Exhausted: FailureDeadLetter},
+		FailureMappingConflict: {Action: FailureDeadLetter},
+		FailureVersionConflict: {Action: FailureDrop},
+		FailureOther:           {Action: FailureDeadLetter},
+	}
+}
+
+var retryableErrorTypes = map[string]bool{
+	"es_rejected_execution_exception":         true,
+	"unavailable_shards_exception":            true,
+	"no_shard_available_action_exception":     true,
+	"node_not_connected_exception":            true,
+	"node_disconnected_exception":             true,
+	"shard_not_in_primary_mode_exception":     true,
+	"circuit_breaking_exception":              true,
+	"process_cluster_event_timeout_exception": true,
+}
+
+var mappingErrorTypes = map[string]bool{
+	"mapper_parsing_exception":         true,
+	"strict_dynamic_mapping_exception": true,
+	"illegal_argument_exception":       true,
+	"document_parsing_exception":       true,
+	"index_not_found_exception":        true,
+}
+
+// ClassifyBulkFailure 根据状态码、错误类型以及请求的version_type对失败的文档分类
+func ClassifyBulkFailure(request elastic.BulkableRequest, item *elastic.BulkResponseItem) FailureClass {
+	errorType := ""
+	if item.Error != nil {
+		errorType = item.Error.Type
+	}
+	switch {
+	case item.Status == http.StatusConflict || errorType == "version_conflict_engine_exception":
+		if isExternalVersion(request) {
+			return FailureVersionConflict
+		}
+		return FailureOther
+	case item.Status == http.StatusTooManyRequests, item.Status == http.StatusServiceUnavailable,
+		item.Status == http.StatusGatewayTimeout, retryableErrorTypes[errorType]:
+		return FailureRetryable
+	case mappingErrorTypes[errorType] || strings.HasSuffix(errorType, "mapping_exception"):
+		return FailureMappingConflict
+	default:
+		return FailureOther
+	}
+}
+
+// isExternalVersion 请求是否使用external或external_gte版本号
+func isExternalVersion(request elastic.BulkableRequest) bool {
+	if request == nil {
+		return false
+	}
+	lines, err := request.Source()
+	if err != nil || len(lines) == 0 {
+		return false
+	}
+	meta := make(map[string]*bulkMeta, 1)
+	if err = json.Unmarshal([]byte(lines[0]), &meta); err != nil {
+		return false
+	}
+	for _, m := range meta {
+		if m != nil && strings.HasPrefix(m.VersionType, "external") {
+			return true
+		}
+	}
+	return false
+}
+
+// failurePolicy 未配置的失败类型使用DefaultFailurePolicies中的策略
+func (b *Bulk) failurePolicy(class FailureClass) *FailurePolicy {
+	if p, ok := b.FailurePolicies[class]; ok && p != nil {
+		return p
+	}
+	if p, ok := DefaultFailurePolicies()[class]; ok {
+		return p
+	}
+	return &FailurePolicy{Action: FailureDeadLetter}
+}
+
+func (p *FailurePolicy) backoff() elastic.Backoff {
+	if p.Backoff != nil {
+		return p.Backoff
+	}
+	return elastic.NewExponentialBackoff(defaultBulkInitialBackoff, defaultBulkMaxBackoff)
+}
+
+// handleBulkItemFailures 按FailurePolicy处理部分失败的bulk响应，返回需要写入死信的文档
+func (c *Client) handleBulkItemFailures(requests []elastic.BulkableRequest, response *elastic.BulkResponse) []*DeadLetter {
+	entries := make([]*DeadLetter, 0)
+	for i, request := range requests {
+		item := bulkResponseItem(response, i)
+		if item == nil || (item.Error == nil && item.Status < 300) {
+			c.bulkRetries.Delete(request)
+			continue
+		}
+		class := ClassifyBulkFailure(request, item)
+		policy := c.Bulk.failurePolicy(class)
+		action := policy.Action
+		if action == FailureRetry {
+			if c.scheduleBulkRetry(request, policy) {
+				continue
+			}
+			action = policy.Exhausted
+		}
+		c.bulkRetries.Delete(request)
+		if action == FailureDrop {
+			c.logger().Debug("drop failed bulk item", Any("client", c.Name), Any("class", string(class)),
+				Any("index", item.Index), Any("id", item.Id), Any("status", item.Status))
+			continue
+		}
+		entry := c.newDeadLetter(request, item.Status, item.Error)
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// scheduleBulkRetry 退避后重新提交请求，重试次数用尽或BulkProcessor已关闭时返回false
+func (c *Client) scheduleBulkRetry(request elastic.BulkableRequest, policy *FailurePolicy) bool {
+	attempt := 0
+	if v, ok := c.bulkRetries.Load(request); ok {
+		attempt = v.(int)
+	}
+	if attempt >= policy.MaxRetries {
+		return false
+	}
+	wait, ok := policy.backoff().Next(attempt)
+	if !ok {
+		return false
+	}
+	c.bulkMu.Lock()
+	defer c.bulkMu.Unlock()
+	if c.bulkClosed {
+		return false
+	}
+	c.bulkRetries.Store(request, attempt+1)
+	c.pendingRetries.Add(1)
+	atomic.AddInt64(&c.retrying, 1)
+	if c.retryTimers == nil {
+		c.retryTimers = make(map[elastic.BulkableRequest]*time.Timer)
+	}
+	c.retryTimers[request] = time.AfterFunc(wait, func() {
+		defer c.pendingRetries.Done()
+		defer atomic.AddInt64(&c.retrying, -1)
+		c.bulkMu.Lock()
+		delete(c.retryTimers, request)
+		c.bulkMu.Unlock()
+		c.addBulkRequest(request)
+	})
+	return true
+}
+
+// stopBulkRetries 停止还在退避中的重试并返回对应的请求，调用方需持有bulkMu。
+// 已经触发的重试不受影响，由pendingRetries等待其完成
+func (c *Client) stopBulkRetries() []elastic.BulkableRequest {
+	stopped := make([]elastic.BulkableRequest, 0, len(c.retryTimers))
+	for request, timer := range c.retryTimers {
+		if timer.Stop() {
+			stopped = append(stopped, request)
+			delete(c.retryTimers, request)
+		}
+	}
+	return stopped
+}
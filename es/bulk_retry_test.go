@@ -0,0 +1,91 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestClassifyBulkFailure(t *testing.T) {
+	create := elastic.NewBulkCreateRequest().Index("idx").Id("1").Doc(map[string]interface{}{"a": 1})
+	external := elastic.NewBulkIndexRequest().Index("idx").Id("1").VersionType(DefaultVersionType).Version(2).Doc(map[string]interface{}{"a": 1})
+	cases := []struct {
+		request elastic.BulkableRequest
+		item    *elastic.BulkResponseItem
+		want    FailureClass
+	}{
+		{create, &elastic.BulkResponseItem{Status: 429, Error: &elastic.ErrorDetails{Type: "es_rejected_execution_exception"}}, FailureRetryable},
+		{create, &elastic.BulkResponseItem{Status: 503, Error: &elastic.ErrorDetails{Type: "unavailable_shards_exception"}}, FailureRetryable},
+		{external, &elastic.BulkResponseItem{Status: 409, Error: &elastic.ErrorDetails{Type: "version_conflict_engine_exception"}}, FailureVersionConflict},
+		{create, &elastic.BulkResponseItem{Status: 409, Error: &elastic.ErrorDetails{Type: "version_conflict_engine_exception"}}, FailureOther},
+		{create, &elastic.BulkResponseItem{Status: 400, Error: &elastic.ErrorDetails{Type: "mapper_parsing_exception"}}, FailureMappingConflict},
+		{create, &elastic.BulkResponseItem{Status: 500, Error: &elastic.ErrorDetails{Type: "exception"}}, FailureOther},
+	}
+	for _, c := range cases {
+		if got := ClassifyBulkFailure(c.request, c.item); got != c.want {
+			t.Errorf("%s %d: expected %s, got %s", c.item.Error.Type, c.item.Status, c.want, got)
+		}
+	}
+}
+
+func TestBulkSelectiveRetry(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	queue := NewMemoryDeadLetterQueue(0)
+	bulk := DefaultBulk()
+	bulk.DeadLetter = queue
+	bulk.FailurePolicies[FailureRetryable].Backoff = elastic.NewConstantBackoff(10 * time.Millisecond)
+	c := newTestBulkClient(t, srv.URL, bulk)
+
+	c.BulkCreate("idx", "busy-1", "", map[string]interface{}{"a": 1})
+	c.BulkCreateWithVersion(context.Background(), "idx", "conflict-1", "", 2, map[string]interface{}{"a": 1})
+	c.BulkCreate("idx", "conflict-2", "", map[string]interface{}{"a": 1})
+	c.BulkCreate("idx", "bad-1", "", map[string]interface{}{"a": 1})
+	c.BulkProcessor.Flush()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.BulkProcessor.Flush()
+		if srv.Seen("busy-1") >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if srv.Seen("busy-1") != 2 || srv.Seen("conflict-1") != 1 || srv.Seen("conflict-2") != 1 || srv.Seen("bad-1") != 1 {
+		t.Fatalf("unexpected actions %v", srv.Actions())
+	}
+	//external版本冲突被丢弃，create已存在的冲突写入死信
+	ids := make([]string, 0)
+	for _, entry := range queue.Drain() {
+		ids = append(ids, entry.ID)
+	}
+	sort.Strings(ids)
+	if fmt.Sprint(ids) != "[bad-1 conflict-2]" {
+		t.Fatalf("unexpected dead letters %v", ids)
+	}
+	c.bulkRetries.Range(func(key, value interface{}) bool {
+		t.Fatalf("retry state should be cleared, got %v", value)
+		return false
+	})
+}
+
+func TestBulkDefaultFailurePolicies(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	queue := NewMemoryDeadLetterQueue(0)
+	c := newTestBulkClient(t, srv.URL, &Bulk{Workers: 1, ActionSize: 100, DeadLetter: queue})
+	if c.Bulk.FailurePolicies == nil {
+		t.Fatal("expected default failure policies")
+	}
+
+	c.BulkCreate("idx", "busy-1", "", map[string]interface{}{"a": 1})
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && srv.Seen("busy-1") < 2 {
+		c.BulkProcessor.Flush()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if srv.Seen("busy-1") != 2 || queue.Len() != 0 {
+		t.Fatalf("expected rejected item to be retried, got %v dead letters %d", srv.Actions(), queue.Len())
+	}
+}
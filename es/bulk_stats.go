@@ -0,0 +1,190 @@
+package es
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BulkStats BulkProcessor统计信息的快照，计数均为Client创建以来的累计值
+type BulkStats struct {
+	Client         string
+	Flushed        int64 //flush次数，包含定时flush
+	Committed      int64 //提交bulk请求的次数
+	Indexed        int64
+	Created        int64
+	Updated        int64
+	Deleted        int64
+	Succeeded      int64
+	Failed         int64
+	PendingRetries int64 //等待重试的文档数
+	Workers        []BulkWorkerStats
+	Time           time.Time
+}
+
+type BulkWorkerStats struct {
+	Queued       int64 //worker中等待提交的文档数
+	LastDuration time.Duration
+}
+
+// BulkStats 返回当前BulkProcessor的统计信息
+func (c *Client) BulkStats() BulkStats {
+	stats := BulkStats{
+		Client:         c.Name,
+		PendingRetries: atomic.LoadInt64(&c.retrying),
+		Time:           time.Now(),
+	}
+	if c.BulkProcessor == nil {
+		return stats
+	}
+	s := c.BulkProcessor.Stats()
+	stats.Flushed = s.Flushed
+	stats.Committed = s.Committed
+	stats.Indexed = s.Indexed
+	stats.Created = s.Created
+	stats.Updated = s.Updated
+	stats.Deleted = s.Deleted
+	stats.Succeeded = s.Succeeded
+	stats.Failed = s.Failed
+	stats.Workers = make([]BulkWorkerStats, 0, len(s.Workers))
+	for _, w := range s.Workers {
+		if w == nil {
+			continue
+		}
+		stats.Workers = append(stats.Workers, BulkWorkerStats{Queued: w.Queued, LastDuration: w.LastDuration})
+	}
+	return stats
+}
+
+// Queued 全部worker中等待提交的文档数
+func (s BulkStats) Queued() int64 {
+	var queued int64
+	for _, w := range s.Workers {
+		queued += w.Queued
+	}
+	return queued
+}
+
+// BulkStatsCollector 定期采集已注册Client的BulkStats
+type BulkStatsCollector struct {
+	interval time.Duration
+	mu       sync.RWMutex
+	latest   map[string]BulkStats
+}
+
+func NewBulkStatsCollector(interval time.Duration) *BulkStatsCollector {
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	return &BulkStatsCollector{interval: interval, latest: make(map[string]BulkStats)}
+}
+
+// Start 立即采集一次，之后按interval采集，ctx取消后停止
+func (c *BulkStatsCollector) Start(ctx context.Context) {
+	c.Collect()
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.Collect()
+			}
+		}
+	}()
+}
+
+// Collect 采集一次，已移除的Client不再输出
+func (c *BulkStatsCollector) Collect() {
+	latest := make(map[string]BulkStats)
+	for name, client := range clients.snapshot() {
+		if client != nil {
+			latest[name] = client.BulkStats()
+		}
+	}
+	c.mu.Lock()
+	c.latest = latest
+	c.mu.Unlock()
+}
+
+// Snapshot 最近一次采集的结果，按Client名称排序
+func (c *BulkStatsCollector) Snapshot() []BulkStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats := make([]BulkStats, 0, len(c.latest))
+	for _, s := range c.latest {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Client < stats[j].Client })
+	return stats
+}
+
+func (c *BulkStatsCollector) WritePrometheus(w io.Writer) error {
+	return WritePrometheus(w, c.Snapshot())
+}
+
+// ServeHTTP 以Prometheus文本格式输出最近一次采集的结果
+func (c *BulkStatsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := c.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+type bulkMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(s BulkStats) int64
+}
+
+var bulkMetrics = []bulkMetric{
+	{"es_bulk_flushed_total", "counter", "Number of bulk processor flushes.", func(s BulkStats) int64 { return s.Flushed }},
+	{"es_bulk_committed_total", "counter", "Number of bulk requests committed.", func(s BulkStats) int64 { return s.Committed }},
+	{"es_bulk_indexed_total", "counter", "Number of index actions.", func(s BulkStats) int64 { return s.Indexed }},
+	{"es_bulk_created_total", "counter", "Number of create actions.", func(s BulkStats) int64 { return s.Created }},
+	{"es_bulk_updated_total", "counter", "Number of update actions.", func(s BulkStats) int64 { return s.Updated }},
+	{"es_bulk_deleted_total", "counter", "Number of delete actions.", func(s BulkStats) int64 { return s.Deleted }},
+	{"es_bulk_succeeded_total", "counter", "Number of actions reported as successful.", func(s BulkStats) int64 { return s.Succeeded }},
+	{"es_bulk_failed_total", "counter", "Number of actions reported as failed.", func(s BulkStats) int64 { return s.Failed }},
+	{"es_bulk_pending_retries", "gauge", "Number of failed actions waiting to be retried.", func(s BulkStats) int64 { return s.PendingRetries }},
+}
+
+// WritePrometheus 以Prometheus文本格式(text exposition format 0.0.4)输出统计信息
+func WritePrometheus(w io.Writer, stats []BulkStats) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range bulkMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range stats {
+			fmt.Fprintf(bw, "%s{client=\"%s\"} %d\n", m.name, escapeLabel(s.Client), m.value(s))
+		}
+	}
+	fmt.Fprint(bw, "# HELP es_bulk_worker_queued Number of actions queued in a bulk worker.\n# TYPE es_bulk_worker_queued gauge\n")
+	for _, s := range stats {
+		for i, worker := range s.Workers {
+			fmt.Fprintf(bw, "es_bulk_worker_queued{client=\"%s\",worker=\"%d\"} %d\n", escapeLabel(s.Client), i, worker.Queued)
+		}
+	}
+	fmt.Fprint(bw, "# HELP es_bulk_worker_last_duration_seconds Duration of the last commit of a bulk worker.\n# TYPE es_bulk_worker_last_duration_seconds gauge\n")
+	for _, s := range stats {
+		for i, worker := range s.Workers {
+			fmt.Fprintf(bw, "es_bulk_worker_last_duration_seconds{client=\"%s\",worker=\"%d\"} %g\n", escapeLabel(s.Client), i, worker.LastDuration.Seconds())
+		}
+	}
+	return bw.Flush()
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelReplacer.Replace(v)
+}
@@ -0,0 +1,56 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBulkStats(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	bulk := DefaultBulk()
+	bulk.Workers = 2
+	c := newTestBulkClient(t, srv.URL, bulk)
+
+	c.BulkCreate("idx", "good-1", "", map[string]interface{}{"a": 1})
+	c.BulkCreate("idx", "bad-1", "", map[string]interface{}{"a": "x"})
+	c.BulkUpdate("idx", "good-2", "", map[string]interface{}{"a": 2})
+	c.BulkDelete("idx", "good-3", "", 1)
+	c.BulkCreateWithVersion(context.Background(), "idx", "good-4", "", 1, map[string]interface{}{"a": 4})
+	if err := c.BulkProcessor.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := c.BulkStats()
+	if stats.Client != c.Name || stats.Flushed < 1 || stats.Committed < 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.Created != 2 || stats.Updated != 1 || stats.Deleted != 1 || stats.Indexed != 1 {
+		t.Fatalf("unexpected action counters %+v", stats)
+	}
+	if stats.Succeeded != 4 || stats.Failed != 1 {
+		t.Fatalf("unexpected result counters %+v", stats)
+	}
+	if len(stats.Workers) != 2 || stats.Queued() != 0 {
+		t.Fatalf("unexpected worker stats %+v", stats.Workers)
+	}
+
+	collector := NewBulkStatsCollector(0)
+	collector.Collect()
+	buf := &bytes.Buffer{}
+	if err := collector.WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE es_bulk_failed_total counter",
+		`es_bulk_created_total{client="` + c.Name + `"} 2`,
+		`es_bulk_failed_total{client="` + c.Name + `"} 1`,
+		`es_bulk_worker_queued{client="` + c.Name + `",worker="1"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
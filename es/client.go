@@ -3,17 +3,16 @@ package es
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"github.com/olivere/elastic/v7"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var clients map[string]*Client
-
+// EStdLogger 默认Logger(NewStdLogger)的输出目标，通过SetLogger可替换为结构化日志
 var EStdLogger stdLogger
 
 type stdLogger interface {
@@ -23,52 +22,86 @@ type stdLogger interface {
 }
 
 type Client struct {
-	Name           string
-	Urls           []string
-	QueryLogEnable bool
-	Username       string
-	password       string
-	Bulk           *Bulk
-	Client         *elastic.Client
-	BulkProcessor  *elastic.BulkProcessor
-	DebugMode      bool
-	CacheIndices   sync.Map
-	lock           sync.Mutex
+	Name                 string
+	Urls                 []string
+	QueryLogEnable       bool
+	Username             string
+	password             string
+	Bulk                 *Bulk
+	Client               *elastic.Client
+	BulkProcessor        *elastic.BulkProcessor
+	DebugMode            bool
+	CacheIndices         sync.Map
+	SlowQueryMillisecond int64         //全局慢查询阈值，单次查询可通过WithSlowQueryMillisecond覆盖
+	SlowQuerySink        SlowQuerySink //慢查询记录的接收方，默认输出到Logger
+	Logger               Logger        //为空时使用SetLogger设置的包级别Logger
+	lock                 sync.Mutex
+	closeOnce            sync.Once
+	closeErr             error
+	bulkRetries          sync.Map //重试中的bulk请求及其已重试次数
+	pendingRetries       sync.WaitGroup
+	retryTimers          map[elastic.BulkableRequest]*time.Timer //退避中的重试，受bulkMu保护
+	retrying             int64                                   //等待重试的文档数，atomic
+	bulkPending          int64                                   //已提交到BulkProcessor但还未写入ES的文档数，atomic
+	bulkMu               sync.RWMutex
+	bulkClosed           bool
 }
 
 type Bulk struct {
-	Name          string
-	Workers       int                   //Bulk处理线程数
-	FlushInterval time.Duration         //刷新频率
-	ActionSize    int                   //每次提交的文档数
-	RequestSize   int                   //每次提交文档大小
-	AfterFunc     elastic.BulkAfterFunc //回调函数
-	Ctx           context.Context
+	Name            string
+	Workers         int                             //Bulk处理线程数
+	FlushInterval   time.Duration                   //刷新频率
+	ActionSize      int                             //每次提交的文档数
+	RequestSize     int                             //每次提交文档大小
+	AfterFunc       elastic.BulkAfterFunc           //回调函数
+	DeadLetter      DeadLetterSink                  //写入失败的文档，为空时只打印日志
+	FailurePolicies map[FailureClass]*FailurePolicy //按失败类型重试、丢弃或写入死信，未配置的类型使用DefaultFailurePolicies
+	Ctx             context.Context
+	ownDeadLetter   bool //DeadLetter由配置创建，关闭Client时一起关闭
 }
 
 func DefaultBulk() *Bulk {
 	return &Bulk{
-		Workers:       3,
-		FlushInterval: 1,
-		ActionSize:    500,
-		RequestSize:   5 << 20,
-		AfterFunc:     defaultBulkFunc,
-		Ctx:           context.Background(),
+		Workers:         3,
+		FlushInterval:   time.Second,
+		ActionSize:      500,
+		RequestSize:     5 << 20,
+		AfterFunc:       defaultBulkFunc,
+		FailurePolicies: DefaultFailurePolicies(),
+		Ctx:             context.Background(),
 	}
 }
 
 func defaultBulkFunc(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
-	if err != nil || (response != nil && response.Errors) {
-		res, _ := json.Marshal(response)
-		EStdLogger.Printf("executionId: %d ;requests : %v; response : %s ; err : %+v", executionId, requests, res, err)
+	logBulkFailure(getLogger(), executionId, requests, response, err)
+}
+
+// logBulkFailure 整个bulk请求失败时输出一条日志，部分失败时每个失败的文档输出一条日志
+func logBulkFailure(logger Logger, executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	if err != nil {
+		logger.Error("bulk request failed", Any("execution_id", executionId), Any("actions", len(requests)), Err(err))
+		return
+	}
+	if response == nil || !response.Errors {
+		return
+	}
+	for _, item := range response.Failed() {
+		fields := []Field{
+			Any("execution_id", executionId),
+			Any("index", item.Index),
+			Any("id", item.Id),
+			Any("status", item.Status),
+		}
+		if item.Error != nil {
+			fields = append(fields, Any("error_type", item.Error.Type), Any("reason", item.Error.Reason))
+		}
+		logger.Error("bulk item failed", fields...)
 	}
 }
 
 func InitClient(clientName string, urls []string, username string, password string) error {
-	if clients == nil {
-		clients = make(map[string]*Client, 0)
-	}
 	client := &Client{
+		Name:           clientName,
 		Urls:           urls,
 		QueryLogEnable: false,
 		Username:       username,
@@ -83,15 +116,14 @@ func InitClient(clientName string, urls []string, username string, password stri
 	if err != nil {
 		return err
 	}
-	clients[clientName] = client
-	return nil
+	return ReplaceClient(clientName, client)
 }
 
 func InitSimpleClient(urls []string, username, password string) error {
 	esClient, err := elastic.NewSimpleClient(
 		elastic.SetURL(urls...),
 		elastic.SetBasicAuth(username, password),
-		elastic.SetErrorLog(EStdLogger),
+		elastic.SetErrorLog(&elasticLogger{logger: getLogger(), error: true}),
 	)
 	if err != nil {
 		return err
@@ -115,31 +147,18 @@ func InitSimpleClient(urls []string, username, password string) error {
 		BulkSize(client.Bulk.RequestSize).
 		FlushInterval(client.Bulk.FlushInterval).
 		Stats(true).
-		After(client.Bulk.AfterFunc).
+		RetryItemStatusCodes(). //单个文档的失败由Bulk.FailurePolicies处理
+		After(client.afterBulk).
 		Do(client.Bulk.Ctx)
 	if err != nil {
-		EStdLogger.Print("init bulkProcessor error ", err)
-	}
-	if clients == nil {
-		clients = make(map[string]*Client, 0)
+		client.logger().Error("init bulkProcessor error", Any("client", client.Name), Err(err))
 	}
-	clients[SimpleClient] = client
-	return nil
-}
-
-func getDefaultClient() *http.Client {
-	tr := &http.Transport{
-		DisableKeepAlives: true,
-		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-	}
-	return &http.Client{Transport: tr}
+	return ReplaceClient(SimpleClient, client)
 }
 
 func InitClientWithOptions(clientName string, urls []string, username string, password string, options ...Option) error {
-	if clients == nil {
-		clients = make(map[string]*Client, 0)
-	}
 	client := &Client{
+		Name:           clientName,
 		Urls:           urls,
 		QueryLogEnable: false,
 		Username:       username,
@@ -156,29 +175,43 @@ func InitClientWithOptions(clientName string, urls []string, username string, pa
 	}
 	esOptions := getBaseOptions(username, password, urls...)
 
-	if opt.DebugMode {
-		esOptions = append(esOptions, elastic.SetInfoLog(EStdLogger))
+	client.QueryLogEnable = opt.QueryLogEnable
+	client.DebugMode = opt.DebugMode
+	client.SlowQueryMillisecond = opt.GlobalSlowQueryMillSecond
+	client.SlowQuerySink = opt.SlowQuerySink
+	client.Logger = opt.Logger
+	client.Bulk = opt.Bulk
+	if client.Bulk == nil {
+		client.Bulk = DefaultBulk()
+	}
+
+	if (opt.TLS != nil || opt.Transport.configured) && len(opt.Scheme) == 0 {
+		opt.Scheme = "https"
 	}
 	if len(opt.Scheme) > 0 {
+		httpClient, err := opt.httpClient()
+		if err != nil {
+			client.closeDeadLetter()
+			return err
+		}
 		esOptions = append(esOptions, elastic.SetScheme(opt.Scheme))
-		esOptions = append(esOptions, elastic.SetHttpClient(getDefaultClient()))
+		esOptions = append(esOptions, elastic.SetHttpClient(httpClient))
 		esOptions = append(esOptions, elastic.SetHealthcheck(false))
 	}
 
-	client.QueryLogEnable = opt.QueryLogEnable
-	client.Bulk = opt.Bulk
-	if client.Bulk == nil {
-		client.Bulk = DefaultBulk()
-	}
 	err := client.newClient(esOptions)
 	if err != nil {
+		client.closeDeadLetter()
 		return err
 	}
-	clients[clientName] = client
-	return nil
+	return ReplaceClient(clientName, client)
 }
 
 func (c *Client) newClient(options []elastic.ClientOptionFunc) error {
+	options = append(options, elastic.SetErrorLog(&elasticLogger{logger: c.logger(), error: true}))
+	if c.DebugMode {
+		options = append(options, elastic.SetInfoLog(&elasticLogger{logger: c.logger()}))
+	}
 	client, err := elastic.NewClient(options...)
 	if err != nil {
 		return err
@@ -195,22 +228,26 @@ func (c *Client) newClient(options []elastic.ClientOptionFunc) error {
 
 	//参数合理性校验
 	if c.Bulk.RequestSize > 100*1024*1024 {
-		EStdLogger.Print("Bulk RequestSize must be smaller than 100MB; it will be ignored.")
+		c.logger().Warn("Bulk RequestSize must be smaller than 100MB; it will be ignored.", Any("client", c.Name))
 		c.Bulk.RequestSize = 100 * 1024 * 1024
 	}
 
 	if c.Bulk.ActionSize >= 10000 {
-		EStdLogger.Print("Bulk ActionSize must be smaller than 10000; it will be ignored.")
+		c.logger().Warn("Bulk ActionSize must be smaller than 10000; it will be ignored.", Any("client", c.Name))
 		c.Bulk.ActionSize = 10000
 	}
 
-	if c.Bulk.FlushInterval >= 60 {
-		EStdLogger.Print("Bulk FlushInterval must be smaller than 60s; it will be ignored.")
+	if c.Bulk.FlushInterval >= 60*time.Second {
+		c.logger().Warn("Bulk FlushInterval must be smaller than 60s; it will be ignored.", Any("client", c.Name))
 		c.Bulk.FlushInterval = time.Second * 60
 	}
 	if c.Bulk.AfterFunc == nil {
 		c.Bulk.AfterFunc = defaultBulkFunc
 	}
+	//SDK不再重试单个文档的失败，未配置策略时429等可重试的失败也要按默认策略重试
+	if c.Bulk.FailurePolicies == nil {
+		c.Bulk.FailurePolicies = DefaultFailurePolicies()
+	}
 	if c.Bulk.Ctx == nil {
 		c.Bulk.Ctx = context.Background()
 	}
@@ -222,10 +259,11 @@ func (c *Client) newClient(options []elastic.ClientOptionFunc) error {
 		BulkSize(c.Bulk.RequestSize).
 		FlushInterval(c.Bulk.FlushInterval).
 		Stats(true).
-		After(c.Bulk.AfterFunc).
+		RetryItemStatusCodes(). //单个文档的失败由Bulk.FailurePolicies处理
+		After(c.afterBulk).
 		Do(c.Bulk.Ctx)
 	if err != nil {
-		EStdLogger.Print("init bulkProcessor error ", err)
+		c.logger().Error("init bulkProcessor error", Any("client", c.Name), Err(err))
 	}
 	return nil
 }
@@ -238,7 +276,6 @@ func getBaseOptions(username, password string, urls ...string) []elastic.ClientO
 	//开启Sniff，SDK会定期(默认15分钟一次)嗅探集群中全部节点，将全部节点都加入到连接列表中，
 	//后续新增的节点也会自动加入到可连接列表，但实际生产中我们可能会设置专门的协调节点，所以默认不开启嗅探
 	options = append(options, elastic.SetSniff(false))
-	options = append(options, elastic.SetErrorLog(EStdLogger))
 	return options
 }
 
@@ -249,6 +286,10 @@ type option struct {
 	Bulk                      *Bulk
 	DebugMode                 bool
 	Scheme                    string
+	TLS                       *tls.Config
+	Transport                 transportOption
+	SlowQuerySink             SlowQuerySink
+	Logger                    Logger
 }
 
 const (
@@ -277,20 +318,28 @@ func WithScheme(scheme string) Option {
 	}
 }
 
+func WithDebugMode(debugMode bool) Option {
+	return func(o *option) {
+		o.DebugMode = debugMode
+	}
+}
+
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(o *option) {
+		o.TLS = tlsConfig
+	}
+}
+
 func WithBulk(bulk *Bulk) Option {
 	return func(o *option) {
 		o.Bulk = bulk
 	}
 }
 
+// CloseAll 关闭全部Client，不设置超时，需要超时控制时使用ShutdownAll
 func CloseAll() {
-	for _, c := range clients {
-		if c != nil {
-			err := c.BulkProcessor.Close()
-			if err != nil {
-				EStdLogger.Print("bulk close error", err)
-			}
-		}
+	if err := ShutdownAll(context.Background()); err != nil {
+		getLogger().Error("bulk close error", Err(err))
 	}
 }
 
@@ -307,5 +356,66 @@ func (c *Client) DeleteIndexCache(indexName ...string) {
 }
 
 func (c *Client) Close() error {
-	return c.BulkProcessor.Close()
+	return c.closeBulkProcessor()
+}
+
+// addBulkRequest 提交到BulkProcessor，BulkProcessor关闭后提交的请求写入死信
+func (c *Client) addBulkRequest(request elastic.BulkableRequest) {
+	c.bulkMu.RLock()
+	defer c.bulkMu.RUnlock()
+	if c.bulkClosed || c.BulkProcessor == nil {
+		c.bulkRetries.Delete(request)
+		c.logger().Error("bulk processor is not running", Any("client", c.Name), Any("request", request.String()))
+		if entry := c.newDeadLetter(request, 0, &elastic.ErrorDetails{Reason: "bulk processor is not running"}); entry != nil {
+			c.putDeadLetters(entry)
+		}
+		return
+	}
+	atomic.AddInt64(&c.bulkPending, 1)
+	c.BulkProcessor.Add(request)
+}
+
+// closeBulkProcessor 先flush未提交的请求再关闭BulkProcessor，重复调用只会关闭一次。
+// 还在退避中的重试不再等待，直接写入死信，返回前所有文档都已写入ES或死信
+func (c *Client) closeBulkProcessor() error {
+	c.closeOnce.Do(func() {
+		c.bulkMu.Lock()
+		c.bulkClosed = true
+		stopped := c.stopBulkRetries()
+		c.bulkMu.Unlock()
+		defer c.closeDeadLetter()
+
+		entries := make([]*DeadLetter, 0, len(stopped))
+		for _, request := range stopped {
+			c.bulkRetries.Delete(request)
+			if entry := c.newDeadLetter(request, 0, &elastic.ErrorDetails{Reason: "bulk processor closed before retry"}); entry != nil {
+				entries = append(entries, entry)
+			}
+			atomic.AddInt64(&c.retrying, -1)
+			c.pendingRetries.Done()
+		}
+		c.putDeadLetters(entries...)
+
+		if c.BulkProcessor != nil {
+			if err := c.BulkProcessor.Flush(); err != nil {
+				c.logger().Error("bulk flush error", Any("client", c.Name), Err(err))
+			}
+			c.closeErr = c.BulkProcessor.Close()
+		}
+		//等待已经触发的重试，BulkProcessor关闭后它们会写入死信
+		c.pendingRetries.Wait()
+	})
+	return c.closeErr
+}
+
+// closeDeadLetter 关闭由配置创建的死信文件，调用方传入的DeadLetter由调用方负责关闭
+func (c *Client) closeDeadLetter() {
+	if c.Bulk == nil || !c.Bulk.ownDeadLetter {
+		return
+	}
+	if closer, ok := c.Bulk.DeadLetter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.logger().Error("close dead letter sink error", Any("client", c.Name), Err(err))
+		}
+	}
 }
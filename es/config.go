@@ -0,0 +1,601 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 声明式的es客户端配置，key为Client名称
+//
+//	clients:
+//	  es-default-client:
+//	    urls: ["http://127.0.0.1:9200"]
+//	    username: elastic
+//	    password: changeme
+//	    query_log_enable: true
+//	    slow_query_millisecond: 200
+//	    bulk:
+//	      workers: 3
+//	      flush_interval: 1s
+type Config struct {
+	Clients map[string]*ClientConfig `json:"clients" yaml:"clients"`
+}
+
+type ClientConfig struct {
+	Urls                 []string    `json:"urls" yaml:"urls"`
+	Username             string      `json:"username" yaml:"username"`
+	Password             string      `json:"password" yaml:"password"`
+	Scheme               string      `json:"scheme" yaml:"scheme"`
+	QueryLogEnable       bool        `json:"query_log_enable" yaml:"query_log_enable"`
+	DebugMode            bool        `json:"debug_mode" yaml:"debug_mode"`
+	SlowQueryMillisecond int64       `json:"slow_query_millisecond" yaml:"slow_query_millisecond"`
+	Bulk                 *BulkConfig `json:"bulk" yaml:"bulk"`
+	TLS                  *TLSConfig  `json:"tls" yaml:"tls"`
+	MaxIdleConnsPerHost  int         `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"`
+	IdleConnTimeout      Duration    `json:"idle_conn_timeout" yaml:"idle_conn_timeout"`
+}
+
+type BulkConfig struct {
+	Workers        int      `json:"workers" yaml:"workers"`
+	FlushInterval  Duration `json:"flush_interval" yaml:"flush_interval"`
+	ActionSize     int      `json:"action_size" yaml:"action_size"`
+	RequestSize    int      `json:"request_size" yaml:"request_size"`
+	DeadLetterFile string   `json:"dead_letter_file" yaml:"dead_letter_file"`
+	//key为retryable、mapping_conflict、version_conflict、other
+	FailurePolicies map[string]*FailurePolicyConfig `json:"failure_policies" yaml:"failure_policies"`
+}
+
+type FailurePolicyConfig struct {
+	Action         string   `json:"action" yaml:"action"` //retry、drop、dead_letter
+	MaxRetries     int      `json:"max_retries" yaml:"max_retries"`
+	InitialBackoff Duration `json:"initial_backoff" yaml:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff" yaml:"max_backoff"`
+	Exhausted      string   `json:"exhausted" yaml:"exhausted"` //drop、dead_letter
+}
+
+var failureActions = map[string]FailureAction{
+	"":            FailureDeadLetter,
+	"dead_letter": FailureDeadLetter,
+	"retry":       FailureRetry,
+	"drop":        FailureDrop,
+}
+
+func (p *FailurePolicyConfig) policy() *FailurePolicy {
+	policy := &FailurePolicy{
+		Action:     failureActions[p.Action],
+		MaxRetries: p.MaxRetries,
+		Exhausted:  failureActions[p.Exhausted],
+	}
+	if policy.Action == FailureRetry && policy.MaxRetries == 0 {
+		policy.MaxRetries = defaultBulkMaxRetries
+	}
+	if p.InitialBackoff > 0 || p.MaxBackoff > 0 {
+		initial, max := time.Duration(p.InitialBackoff), time.Duration(p.MaxBackoff)
+		if initial <= 0 {
+			initial = defaultBulkInitialBackoff
+		}
+		if max <= 0 {
+			max = defaultBulkMaxBackoff
+		}
+		policy.Backoff = elastic.NewExponentialBackoff(initial, max)
+	}
+	return policy
+}
+
+type TLSConfig struct {
+	CAFile             string   `json:"ca_file" yaml:"ca_file"`
+	CertFile           string   `json:"cert_file" yaml:"cert_file"`
+	KeyFile            string   `json:"key_file" yaml:"key_file"`
+	ServerName         string   `json:"server_name" yaml:"server_name"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+	Pins               []string `json:"pins" yaml:"pins"`
+}
+
+// Duration 支持"1s"、"500ms"这类写法，纯数字按秒处理
+type Duration time.Duration
+
+func (d *Duration) parse(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		*d = 0
+		return nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		*d = Duration(time.Duration(n) * time.Second)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	return d.parse(strings.Trim(string(data), `"`))
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ConfigError 汇总配置校验中发现的全部问题
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid es config: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ConfigError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// ParseConfig 解析配置内容，format支持yaml、yml、json
+func ParseConfig(data []byte, format string) (*Config, error) {
+	cfg := &Config{}
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "yaml", "yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse es config: %w", err)
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parse es config: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported es config format %q", format)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfigFile 从yaml或json文件加载配置，格式由扩展名决定
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data, filepath.Ext(path))
+}
+
+// LoadConfigFromEnv 从环境变量加载配置，prefix默认为ES
+//
+//	ES_CLIENTS=es-default-client,es-default-read-client
+//	ES_ES_DEFAULT_CLIENT_URLS=http://127.0.0.1:9200,http://127.0.0.2:9200
+//	ES_ES_DEFAULT_CLIENT_BULK_FLUSH_INTERVAL=1s
+//
+// Client名称转为大写，非字母数字字符替换为下划线
+func LoadConfigFromEnv(prefix string) (*Config, error) {
+	if prefix == "" {
+		prefix = "ES"
+	}
+	prefix = strings.TrimSuffix(prefix, "_") + "_"
+	names := splitList(os.Getenv(prefix + "CLIENTS"))
+	if len(names) == 0 {
+		return nil, fmt.Errorf("es config: %sCLIENTS is not set", prefix)
+	}
+	cfg := &Config{Clients: make(map[string]*ClientConfig, len(names))}
+	problems := &ConfigError{}
+	for _, name := range names {
+		key := prefix + envName(name) + "_"
+		env := func(k string) string { return os.Getenv(key + k) }
+		cc := &ClientConfig{
+			Urls:     splitList(env("URLS")),
+			Username: env("USERNAME"),
+			Password: env("PASSWORD"),
+			Scheme:   env("SCHEME"),
+		}
+		envBool(problems, key+"QUERY_LOG_ENABLE", &cc.QueryLogEnable)
+		envBool(problems, key+"DEBUG_MODE", &cc.DebugMode)
+		envInt(problems, key+"MAX_IDLE_CONNS_PER_HOST", &cc.MaxIdleConnsPerHost)
+		if v := env("IDLE_CONN_TIMEOUT"); v != "" {
+			if err := cc.IdleConnTimeout.parse(v); err != nil {
+				problems.add("%sIDLE_CONN_TIMEOUT: %v", key, err)
+			}
+		}
+		envInt64(problems, key+"SLOW_QUERY_MILLISECOND", &cc.SlowQueryMillisecond)
+
+		bulk := &BulkConfig{}
+		set := envInt(problems, key+"BULK_WORKERS", &bulk.Workers)
+		set = envInt(problems, key+"BULK_ACTION_SIZE", &bulk.ActionSize) || set
+		set = envInt(problems, key+"BULK_REQUEST_SIZE", &bulk.RequestSize) || set
+		if v := env("BULK_DEAD_LETTER_FILE"); v != "" {
+			bulk.DeadLetterFile = v
+			set = true
+		}
+		if v := env("BULK_FLUSH_INTERVAL"); v != "" {
+			if err := bulk.FlushInterval.parse(v); err != nil {
+				problems.add("%sBULK_FLUSH_INTERVAL: %v", key, err)
+			}
+			set = true
+		}
+		if set {
+			cc.Bulk = bulk
+		}
+
+		tlsCfg := &TLSConfig{
+			CAFile:     env("TLS_CA_FILE"),
+			CertFile:   env("TLS_CERT_FILE"),
+			KeyFile:    env("TLS_KEY_FILE"),
+			ServerName: env("TLS_SERVER_NAME"),
+			Pins:       splitList(env("TLS_PINS")),
+		}
+		envBool(problems, key+"TLS_INSECURE_SKIP_VERIFY", &tlsCfg.InsecureSkipVerify)
+		if !reflect.DeepEqual(tlsCfg, &TLSConfig{Pins: []string{}}) {
+			cc.TLS = tlsCfg
+		}
+		cfg.Clients[name] = cc
+	}
+	if len(problems.Problems) > 0 {
+		return nil, problems
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+func splitList(s string) []string {
+	res := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func envBool(problems *ConfigError, key string, dst *bool) {
+	if v := os.Getenv(key); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			problems.add("%s: invalid bool %q", key, v)
+			return
+		}
+		*dst = b
+	}
+}
+
+func envInt(problems *ConfigError, key string, dst *int) bool {
+	var v int64
+	if !envInt64(problems, key, &v) {
+		return false
+	}
+	*dst = int(v)
+	return true
+}
+
+func envInt64(problems *ConfigError, key string, dst *int64) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		problems.add("%s: invalid integer %q", key, v)
+		return false
+	}
+	*dst = n
+	return true
+}
+
+// Validate 校验配置，返回*ConfigError
+func (cfg *Config) Validate() error {
+	problems := &ConfigError{}
+	if len(cfg.Clients) == 0 {
+		problems.add("clients: at least one client is required")
+	}
+	for _, name := range cfg.clientNames() {
+		cc := cfg.Clients[name]
+		path := "clients." + name
+		if cc == nil {
+			problems.add("%s: empty client section", path)
+			continue
+		}
+		cc.validate(path, problems)
+	}
+	if len(problems.Problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (cfg *Config) clientNames() []string {
+	names := make([]string, 0, len(cfg.Clients))
+	for name := range cfg.Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (cc *ClientConfig) validate(path string, problems *ConfigError) {
+	if len(cc.Urls) == 0 {
+		problems.add("%s.urls: at least one url is required", path)
+	}
+	for _, u := range cc.Urls {
+		parsed, err := url.Parse(u)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			problems.add("%s.urls: invalid url %q", path, u)
+		}
+	}
+	if cc.Scheme != "" && cc.Scheme != "http" && cc.Scheme != "https" {
+		problems.add("%s.scheme: must be http or https, got %q", path, cc.Scheme)
+	}
+	if cc.MaxIdleConnsPerHost < 0 {
+		problems.add("%s.max_idle_conns_per_host: must not be negative", path)
+	}
+	if cc.IdleConnTimeout < 0 {
+		problems.add("%s.idle_conn_timeout: must not be negative", path)
+	}
+	if cc.SlowQueryMillisecond < 0 {
+		problems.add("%s.slow_query_millisecond: must not be negative", path)
+	}
+	if b := cc.Bulk; b != nil {
+		if b.Workers < 0 {
+			problems.add("%s.bulk.workers: must not be negative", path)
+		}
+		if b.ActionSize < 0 || b.ActionSize >= 10000 {
+			problems.add("%s.bulk.action_size: must be in [0, 10000)", path)
+		}
+		if b.RequestSize < 0 || b.RequestSize > 100*1024*1024 {
+			problems.add("%s.bulk.request_size: must be in [0, 100MB]", path)
+		}
+		if b.FlushInterval < 0 || time.Duration(b.FlushInterval) >= 60*time.Second {
+			problems.add("%s.bulk.flush_interval: must be in [0, 60s)", path)
+		}
+		classes := make([]string, 0, len(b.FailurePolicies))
+		for class := range b.FailurePolicies {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			p := b.FailurePolicies[class]
+			switch FailureClass(class) {
+			case FailureRetryable, FailureMappingConflict, FailureVersionConflict, FailureOther:
+			default:
+				problems.add("%s.bulk.failure_policies: unknown failure class %q", path, class)
+			}
+			if p == nil {
+				continue
+			}
+			if _, ok := failureActions[p.Action]; !ok {
+				problems.add("%s.bulk.failure_policies.%s.action: unknown action %q", path, class, p.Action)
+			}
+			if a, ok := failureActions[p.Exhausted]; !ok || a == FailureRetry {
+				problems.add("%s.bulk.failure_policies.%s.exhausted: must be drop or dead_letter", path, class)
+			}
+			if p.MaxRetries < 0 {
+				problems.add("%s.bulk.failure_policies.%s.max_retries: must not be negative", path, class)
+			}
+		}
+	}
+	if t := cc.TLS; t != nil {
+		if cc.Scheme == "http" {
+			problems.add("%s.tls: tls requires scheme https", path)
+		}
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			problems.add("%s.tls: cert_file and key_file must be set together", path)
+		}
+		for _, f := range [][2]string{{"ca_file", t.CAFile}, {"cert_file", t.CertFile}, {"key_file", t.KeyFile}} {
+			if f[1] == "" {
+				continue
+			}
+			if _, err := os.Stat(f[1]); err != nil {
+				problems.add("%s.tls.%s: %v", path, f[0], err)
+			}
+		}
+		for _, pin := range t.Pins {
+			if _, err := decodePin(pin); err != nil {
+				problems.add("%s.tls.pins: %v", path, err)
+			}
+		}
+	}
+}
+
+// Options 将配置转换为InitClientWithOptions使用的Option
+func (cc *ClientConfig) Options() ([]Option, error) {
+	options := []Option{
+		WithQueryLogEnable(cc.QueryLogEnable),
+		WithDebugMode(cc.DebugMode),
+		WithSlowQueryLogMilliseconde(cc.SlowQueryMillisecond),
+	}
+	if cc.Scheme != "" {
+		options = append(options, WithScheme(cc.Scheme))
+	}
+	if cc.Bulk != nil {
+		bulk := DefaultBulk()
+		if cc.Bulk.Workers > 0 {
+			bulk.Workers = cc.Bulk.Workers
+		}
+		if cc.Bulk.FlushInterval > 0 {
+			bulk.FlushInterval = time.Duration(cc.Bulk.FlushInterval)
+		}
+		if cc.Bulk.ActionSize > 0 {
+			bulk.ActionSize = cc.Bulk.ActionSize
+		}
+		if cc.Bulk.RequestSize > 0 {
+			bulk.RequestSize = cc.Bulk.RequestSize
+		}
+		for class, p := range cc.Bulk.FailurePolicies {
+			if p != nil {
+				bulk.FailurePolicies[FailureClass(class)] = p.policy()
+			}
+		}
+		if cc.Bulk.DeadLetterFile != "" {
+			sink, err := NewFileDeadLetterSink(cc.Bulk.DeadLetterFile)
+			if err != nil {
+				return nil, err
+			}
+			bulk.DeadLetter = sink
+			bulk.ownDeadLetter = true
+		}
+		options = append(options, WithBulk(bulk))
+	}
+	if cc.TLS != nil {
+		options = append(options, cc.TLS.options()...)
+	}
+	if cc.MaxIdleConnsPerHost > 0 {
+		options = append(options, WithMaxIdleConnsPerHost(cc.MaxIdleConnsPerHost))
+	}
+	if cc.IdleConnTimeout > 0 {
+		options = append(options, WithIdleConnTimeout(time.Duration(cc.IdleConnTimeout)))
+	}
+	return options, nil
+}
+
+func (t *TLSConfig) options() []Option {
+	options := []Option{WithInsecureSkipVerify(t.InsecureSkipVerify)}
+	if t.CAFile != "" {
+		options = append(options, WithCACertFile(t.CAFile))
+	}
+	if t.CertFile != "" {
+		options = append(options, WithClientCertFile(t.CertFile, t.KeyFile))
+	}
+	if t.ServerName != "" {
+		options = append(options, WithServerName(t.ServerName))
+	}
+	if len(t.Pins) > 0 {
+		options = append(options, WithCertificatePins(t.Pins...))
+	}
+	return options
+}
+
+// InitClientsFromConfig 按配置初始化全部Client，已存在的同名Client会被替换
+func InitClientsFromConfig(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	for _, name := range cfg.clientNames() {
+		if err := initClientFromConfig(name, cfg.Clients[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func initClientFromConfig(name string, cc *ClientConfig) error {
+	options, err := cc.Options()
+	if err != nil {
+		return fmt.Errorf("es client %s: %w", name, err)
+	}
+	if err = InitClientWithOptions(name, cc.Urls, cc.Username, cc.Password, options...); err != nil {
+		return fmt.Errorf("es client %s: %w", name, err)
+	}
+	return nil
+}
+
+// WatchConfigFile 加载配置文件并初始化Client，之后按interval轮询文件，
+// 某个Client的配置段发生变化时重建该Client，配置段被删除时移除该Client。
+// 因为Client可能被重建，调用方应通过GetClient获取Client而不要长期持有，RWClient会按名称跟踪重建后的Client。
+func WatchConfigFile(ctx context.Context, path string, interval time.Duration) error {
+	//解析和后续比较使用同一份内容，避免加载之后文件的修改被当作基准而丢失
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := ParseConfig(data, filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	if err = InitClientsFromConfig(cfg); err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := os.ReadFile(path)
+			if err != nil {
+				getLogger().Error("read es config error", Any("path", path), Err(err))
+				continue
+			}
+			if bytes.Equal(current, data) {
+				continue
+			}
+			data = current
+			next, err := ParseConfig(current, filepath.Ext(path))
+			if err != nil {
+				getLogger().Error("reload es config error", Any("path", path), Err(err))
+				continue
+			}
+			cfg = applyConfigChange(cfg, next)
+		}
+	}()
+	return nil
+}
+
+// applyConfigChange 只重建配置发生变化的Client，返回实际生效的配置
+func applyConfigChange(prev, next *Config) *Config {
+	applied := &Config{Clients: make(map[string]*ClientConfig, len(next.Clients))}
+	for _, name := range next.clientNames() {
+		cc := next.Clients[name]
+		if old, ok := prev.Clients[name]; ok && reflect.DeepEqual(old, cc) {
+			applied.Clients[name] = old
+			continue
+		}
+		if err := initClientFromConfig(name, cc); err != nil {
+			getLogger().Error("rebuild es client error", Any("client", name), Err(err))
+			if old, ok := prev.Clients[name]; ok {
+				applied.Clients[name] = old
+			}
+			continue
+		}
+		getLogger().Info("es client rebuilt from config", Any("client", name))
+		applied.Clients[name] = cc
+	}
+	for name := range prev.Clients {
+		if _, ok := next.Clients[name]; ok {
+			continue
+		}
+		if err := RemoveClient(name); err != nil {
+			getLogger().Error("remove es client error", Any("client", name), Err(err))
+		}
+		getLogger().Info("es client removed from config", Any("client", name))
+	}
+	return applied
+}
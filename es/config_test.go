@@ -0,0 +1,245 @@
+package es
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	data := []byte(`
+clients:
+  es-default-client:
+    urls: ["http://127.0.0.1:9200"]
+    username: elastic
+    query_log_enable: true
+    slow_query_millisecond: 200
+    bulk:
+      workers: 2
+      flush_interval: 500ms
+`)
+	cfg, err := ParseConfig(data, "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := cfg.Clients[DefaultClient]
+	if cc == nil || cc.Username != "elastic" || !cc.QueryLogEnable || cc.SlowQueryMillisecond != 200 {
+		t.Fatalf("unexpected client config %+v", cc)
+	}
+	if cc.Bulk.Workers != 2 || time.Duration(cc.Bulk.FlushInterval) != 500*time.Millisecond {
+		t.Fatalf("unexpected bulk config %+v", cc.Bulk)
+	}
+
+	jsonCfg, err := ParseConfig([]byte(`{"clients":{"a":{"urls":["https://es:9200"],"bulk":{"flush_interval":"2s"}}}}`), ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(jsonCfg.Clients["a"].Bulk.FlushInterval) != 2*time.Second {
+		t.Fatalf("unexpected flush interval %v", jsonCfg.Clients["a"].Bulk.FlushInterval)
+	}
+}
+
+func TestParseConfigValidation(t *testing.T) {
+	data := []byte(`
+clients:
+  bad:
+    urls: ["127.0.0.1:9200"]
+    scheme: ftp
+    bulk:
+      action_size: 20000
+      flush_interval: 2m
+`)
+	_, err := ParseConfig(data, "yml")
+	cfgErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatalf("expected *ConfigError, got %v", err)
+	}
+	if len(cfgErr.Problems) != 4 {
+		t.Fatalf("expected 4 problems, got %v", cfgErr.Problems)
+	}
+	if !strings.Contains(err.Error(), "clients.bad.scheme") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestInitClientsFromConfigBulk(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	cfg, err := ParseConfig([]byte(`{"clients":{"config-bulk":{"urls":["`+srv.URL+`"],"bulk":{"flush_interval":"500ms"}}}}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = InitClientsFromConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveClient("config-bulk")
+	if interval := MustGetClient("config-bulk").Bulk.FlushInterval; interval != 500*time.Millisecond {
+		t.Fatalf("expected flush interval 500ms, got %v", interval)
+	}
+	if interval := DefaultBulk().FlushInterval; interval != time.Second {
+		t.Fatalf("expected default flush interval 1s, got %v", interval)
+	}
+}
+
+func TestRemoveClientClosesDeadLetterFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	cfg, err := ParseConfig([]byte(`{"clients":{"config-dead-letter":{"urls":["`+srv.URL+`"],"bulk":{"dead_letter_file":"`+path+`"}}}}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = InitClientsFromConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	sink := MustGetClient("config-dead-letter").Bulk.DeadLetter.(*FileDeadLetterSink)
+	if err = InitClientsFromConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Put(&DeadLetter{Index: "idx"}); err == nil {
+		t.Fatal("expected dead letter file of the replaced client to be closed")
+	}
+	sink = MustGetClient("config-dead-letter").Bulk.DeadLetter.(*FileDeadLetterSink)
+	if err = RemoveClient("config-dead-letter"); err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Put(&DeadLetter{Index: "idx"}); err == nil {
+		t.Fatal("expected dead letter file to be closed after RemoveClient")
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("ES_CLIENTS", DefaultClient)
+	t.Setenv("ES_ES_DEFAULT_CLIENT_URLS", "http://127.0.0.1:9200, http://127.0.0.2:9200")
+	t.Setenv("ES_ES_DEFAULT_CLIENT_DEBUG_MODE", "true")
+	t.Setenv("ES_ES_DEFAULT_CLIENT_BULK_WORKERS", "4")
+	cfg, err := LoadConfigFromEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := cfg.Clients[DefaultClient]
+	if len(cc.Urls) != 2 || !cc.DebugMode || cc.Bulk == nil || cc.Bulk.Workers != 4 {
+		t.Fatalf("unexpected client config %+v", cc)
+	}
+
+	t.Setenv("ES_ES_DEFAULT_CLIENT_DEBUG_MODE", "yes please")
+	if _, err = LoadConfigFromEnv("ES"); err == nil {
+		t.Fatal("expected invalid bool error")
+	}
+}
+
+func TestWatchConfigFileReload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "es.json")
+	write := func(flush string) {
+		data := `{"clients":{"config-watch":{"urls":["` + srv.URL + `"],"bulk":{"flush_interval":"` + flush + `"}}}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("500ms")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := WatchConfigFile(ctx, path, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveClient("config-watch")
+	first := MustGetClient("config-watch")
+
+	//内容不变时不重建
+	time.Sleep(50 * time.Millisecond)
+	if MustGetClient("config-watch") != first {
+		t.Fatal("expected unchanged config to keep the client")
+	}
+	write("2s")
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && MustGetClient("config-watch") == first {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c := MustGetClient("config-watch"); c == first || c.Bulk.FlushInterval != 2*time.Second {
+		t.Fatal("expected client to be rebuilt after the file changed")
+	}
+}
+
+func TestConfigReloadUpdatesRWClient(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	config := func(flush string) *Config {
+		cfg, err := ParseConfig([]byte(`{"clients":{
+			"config-rw-read":{"urls":["`+srv.URL+`"]},
+			"config-rw-write":{"urls":["`+srv.URL+`"],"bulk":{"flush_interval":"`+flush+`"}}}}`), "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	prev := config("30s")
+	if err := InitClientsFromConfig(prev); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveClient("config-rw-read")
+	defer RemoveClient("config-rw-write")
+	read, oldWrite := MustGetClient("config-rw-read"), MustGetClient("config-rw-write")
+	rw, err := NewRWClient(read, oldWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applyConfigChange(prev, config("20s"))
+	write := MustGetClient("config-rw-write")
+	if write == oldWrite || !oldWrite.bulkClosed {
+		t.Fatal("expected write client to be rebuilt and the old one closed")
+	}
+	if rw.Write() != write || rw.Read() != read {
+		t.Fatal("expected RWClient to use the rebuilt write client")
+	}
+	rw.BulkCreate("idx", "after-reload", "", map[string]interface{}{"a": 1})
+	if err = write.BulkProcessor.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Seen("after-reload") != 1 {
+		t.Fatalf("expected write through the rebuilt client, got %v", srv.Actions())
+	}
+}
+
+func TestInitClientClosesDeadLetterFileOnTransportError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig([]byte(`{"clients":{"config-bad-tls":{"urls":["https://127.0.0.1:9200"],
+		"bulk":{"dead_letter_file":"`+path+`"},"tls":{"ca_file":"`+caFile+`"}}}}`), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := cfg.Clients["config-bad-tls"].Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := &option{}
+	for _, f := range options {
+		f(opt)
+	}
+	if err = InitClientWithOptions("config-bad-tls", []string{"https://127.0.0.1:9200"}, "", "", options...); err == nil {
+		RemoveClient("config-bad-tls")
+		t.Fatal("expected invalid CA file error")
+	}
+	if err = opt.Bulk.DeadLetter.Put(&DeadLetter{Index: "idx"}); err == nil {
+		t.Fatal("expected dead letter file to be closed when the transport cannot be built")
+	}
+}
@@ -0,0 +1,49 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"strings"
+)
+
+// WithTerminateAfter 每个分片最多收集terminateAfter条文档，Count的结果不会超过分片数*terminateAfter
+func WithTerminateAfter(terminateAfter int) QueryOption {
+	return func(opt *queryOption) {
+		opt.TerminateAfter = terminateAfter
+	}
+}
+
+// Count 统计命中的文档数，query为nil时统计全部文档
+func (c *Client) Count(ctx context.Context, indexName string, routes []string, query elastic.Query, options ...QueryOption) (int64, error) {
+	queryOpt := newQueryOption(options)
+	countService := c.Client.Count(indexName).IgnoreUnavailable(true).Preference(queryOpt.preference())
+	if len(routes) > 0 {
+		countService.Routing(strings.Join(routes, ","))
+	}
+	if query != nil {
+		countService.Query(query)
+	}
+	if queryOpt.TerminateAfter > 0 {
+		countService.TerminateAfter(queryOpt.TerminateAfter)
+	}
+	if c.DebugMode || c.QueryLogEnable || queryOpt.EnableDSL {
+		var data []byte
+		if query != nil {
+			src, _ := query.Source()
+			data, _ = json.Marshal(map[string]interface{}{"query": src})
+		}
+		c.logger().Info("es count", Any("index", indexName), Any("dsl", string(data)), Any("routing", strings.Join(routes, ",")))
+	}
+	return countService.Do(ctx)
+}
+
+// ExistsByQuery 判断是否存在命中的文档，每个分片找到一条即停止
+func (c *Client) ExistsByQuery(ctx context.Context, indexName string, routes []string, query elastic.Query, options ...QueryOption) (bool, error) {
+	options = append(options[:len(options):len(options)], WithTerminateAfter(1))
+	count, err := c.Count(ctx, indexName, routes, query, options...)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
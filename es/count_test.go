@@ -0,0 +1,48 @@
+package es
+
+import (
+	"context"
+	"github.com/olivere/elastic/v7"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCountAndExistsByQuery(t *testing.T) {
+	var (
+		params url.Values
+		body   string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		data, _ := io.ReadAll(r.Body)
+		params, body = r.URL.Query(), string(data)
+		if params.Get("terminate_after") == "1" {
+			w.Write([]byte(`{"count":0}`))
+			return
+		}
+		w.Write([]byte(`{"count":42}`))
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	count, err := c.Count(ctx, "idx", []string{"r1", "r2"}, elastic.NewTermQuery("f", "v"))
+	if err != nil || count != 42 {
+		t.Fatalf("unexpected count %d %v", count, err)
+	}
+	if params.Get("routing") != "r1,r2" || params.Get("preference") != DefaultPreference || !strings.Contains(body, `"term"`) {
+		t.Fatalf("unexpected count request %v %s", params, body)
+	}
+
+	exists, err := c.ExistsByQuery(ctx, "idx", nil, elastic.NewTermQuery("f", "v"), WithPreference("_primary"))
+	if err != nil || exists {
+		t.Fatalf("unexpected exists %v %v", exists, err)
+	}
+	if params.Get("terminate_after") != "1" || params.Get("preference") != "_primary" {
+		t.Fatalf("unexpected exists request %v", params)
+	}
+}
@@ -0,0 +1,283 @@
+package es
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DeadLetter 写入失败的bulk文档，保留原始的action行和文档内容，可以原样重放
+type DeadLetter struct {
+	Client    string          `json:"client"`
+	Action    string          `json:"action"` //index、create、update、delete
+	Index     string          `json:"index"`
+	ID        string          `json:"id,omitempty"`
+	Routing   string          `json:"routing,omitempty"`
+	Meta      json.RawMessage `json:"meta"` //原始action行，包含version、version_type等信息
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    int             `json:"status"`
+	ErrorType string          `json:"error_type,omitempty"`
+	Reason    string          `json:"reason"`
+	Time      time.Time       `json:"time"`
+}
+
+// DeadLetterSink 死信接收方
+type DeadLetterSink interface {
+	Put(entries ...*DeadLetter) error
+}
+
+type DeadLetterFunc func(entries ...*DeadLetter) error
+
+func (f DeadLetterFunc) Put(entries ...*DeadLetter) error {
+	return f(entries...)
+}
+
+// FileDeadLetterSink 以NDJSON格式追加写入本地文件
+type FileDeadLetterSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+func (s *FileDeadLetterSink) Put(entries ...*DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileDeadLetterSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// ReadDeadLetterFile 读取FileDeadLetterSink写入的文件
+func ReadDeadLetterFile(path string) ([]*DeadLetter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := make([]*DeadLetter, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 100*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry := &DeadLetter{}
+		if err = json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// MemoryDeadLetterQueue 内存队列，超过max条时丢弃最早的记录，max<=0表示不限制
+type MemoryDeadLetterQueue struct {
+	mu      sync.Mutex
+	entries []*DeadLetter
+	max     int
+}
+
+func NewMemoryDeadLetterQueue(max int) *MemoryDeadLetterQueue {
+	return &MemoryDeadLetterQueue{max: max}
+}
+
+func (q *MemoryDeadLetterQueue) Put(entries ...*DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.entries = append(q.entries, entries...)
+	if q.max > 0 && len(q.entries) > q.max {
+		q.entries = q.entries[len(q.entries)-q.max:]
+	}
+	return nil
+}
+
+func (q *MemoryDeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Drain 取出并清空队列中的全部记录
+func (q *MemoryDeadLetterQueue) Drain() []*DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := q.entries
+	q.entries = nil
+	return entries
+}
+
+// bulkMeta bulk请求action行中的元数据
+type bulkMeta struct {
+	Index       string `json:"_index"`
+	ID          string `json:"_id"`
+	Routing     string `json:"routing"`
+	VersionType string `json:"version_type"`
+}
+
+// deadLetterFromRequest 根据原始请求构造死信
+func deadLetterFromRequest(request elastic.BulkableRequest) (*DeadLetter, error) {
+	lines, err := request.Source()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("es: empty bulk request")
+	}
+	meta := make(map[string]*bulkMeta, 1)
+	if err = json.Unmarshal([]byte(lines[0]), &meta); err != nil {
+		return nil, err
+	}
+	entry := &DeadLetter{Meta: json.RawMessage(lines[0]), Time: time.Now()}
+	for action, m := range meta {
+		entry.Action = action
+		if m != nil {
+			entry.Index, entry.ID, entry.Routing = m.Index, m.ID, m.Routing
+		}
+	}
+	if len(lines) > 1 {
+		entry.Payload = json.RawMessage(lines[1])
+	}
+	return entry, nil
+}
+
+func (c *Client) newDeadLetter(request elastic.BulkableRequest, status int, details *elastic.ErrorDetails) *DeadLetter {
+	entry, err := deadLetterFromRequest(request)
+	if err != nil {
+		c.logger().Error("build dead letter error", Any("client", c.Name), Any("request", request.String()), Err(err))
+		return nil
+	}
+	entry.Client = c.Name
+	entry.Status = status
+	if details != nil {
+		entry.ErrorType, entry.Reason = details.Type, details.Reason
+	}
+	return entry
+}
+
+func (c *Client) putDeadLetters(entries ...*DeadLetter) {
+	if len(entries) == 0 || c.Bulk == nil || c.Bulk.DeadLetter == nil {
+		return
+	}
+	if err := c.Bulk.DeadLetter.Put(entries...); err != nil {
+		c.logger().Error("put dead letter error", Any("client", c.Name), Any("entries", len(entries)), Err(err))
+	}
+}
+
+// bulkResponseItem bulk响应中的items与请求一一对应
+func bulkResponseItem(response *elastic.BulkResponse, i int) *elastic.BulkResponseItem {
+	if response == nil || i >= len(response.Items) {
+		return nil
+	}
+	for _, item := range response.Items[i] {
+		return item
+	}
+	return nil
+}
+
+// afterBulk BulkProcessor的回调，先执行Bulk.AfterFunc，再按Bulk.FailurePolicies处理失败的文档。
+// err不为空表示整个请求失败(SDK已经按Backoff重试过)，全部文档写入死信
+func (c *Client) afterBulk(executionId int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	atomic.AddInt64(&c.bulkPending, -int64(len(requests)))
+	if c.Bulk.AfterFunc != nil {
+		c.Bulk.AfterFunc(executionId, requests, response, err)
+	}
+	entries := make([]*DeadLetter, 0)
+	switch {
+	case err != nil:
+		for _, request := range requests {
+			c.bulkRetries.Delete(request)
+			if entry := c.newDeadLetter(request, 0, &elastic.ErrorDetails{Reason: err.Error()}); entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+	case response != nil && response.Errors:
+		entries = c.handleBulkItemFailures(requests, response)
+	default:
+		for _, request := range requests {
+			c.bulkRetries.Delete(request)
+		}
+	}
+	c.putDeadLetters(entries...)
+}
+
+// rawBulkRequest 使用原始的action行和文档内容重新构造bulk请求
+type rawBulkRequest struct {
+	lines []string
+}
+
+func (r *rawBulkRequest) String() string {
+	return fmt.Sprintf("%v", r.lines)
+}
+
+func (r *rawBulkRequest) Source() ([]string, error) {
+	return r.lines, nil
+}
+
+func (d *DeadLetter) request() (elastic.BulkableRequest, error) {
+	if len(d.Meta) == 0 {
+		return nil, errors.New("es: dead letter without meta")
+	}
+	lines := []string{string(d.Meta)}
+	if len(d.Payload) > 0 {
+		lines = append(lines, string(d.Payload))
+	}
+	return &rawBulkRequest{lines: lines}, nil
+}
+
+// ReplayDeadLetters 将死信重新提交到BulkProcessor，返回成功提交的数量
+func (c *Client) ReplayDeadLetters(entries []*DeadLetter) (int, error) {
+	c.bulkMu.RLock()
+	closed := c.bulkClosed || c.BulkProcessor == nil
+	c.bulkMu.RUnlock()
+	if closed {
+		return 0, errors.New("es: bulk processor is not running")
+	}
+	replayed := 0
+	for _, entry := range entries {
+		request, err := entry.request()
+		if err != nil {
+			return replayed, err
+		}
+		c.addBulkRequest(request)
+		replayed++
+	}
+	return replayed, nil
+}
+
+// ReplayDeadLetterFile 读取死信文件并重新提交到BulkProcessor
+func (c *Client) ReplayDeadLetterFile(path string) (int, error) {
+	entries, err := ReadDeadLetterFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return c.ReplayDeadLetters(entries)
+}
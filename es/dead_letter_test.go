@@ -0,0 +1,146 @@
+package es
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBulkServer 模拟ES的_bulk接口，_id以bad开头的文档返回mapper_parsing_exception，
+// 以conflict开头的返回版本冲突，以busy开头的第一次返回429
+type fakeBulkServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	actions []string
+	seen    map[string]int
+}
+
+func newFakeBulkServer(t *testing.T) *fakeBulkServer {
+	f := &fakeBulkServer{seen: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			w.Write([]byte(`{}`))
+			return
+		}
+		items := make([]map[string]interface{}, 0)
+		hasErrors := false
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			meta := map[string]map[string]interface{}{}
+			if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+				continue
+			}
+			for action, m := range meta {
+				if action != "delete" {
+					scanner.Scan()
+				}
+				id, _ := m["_id"].(string)
+				f.mu.Lock()
+				f.actions = append(f.actions, action+":"+id)
+				f.seen[id]++
+				seen := f.seen[id]
+				f.mu.Unlock()
+				item := map[string]interface{}{"_index": m["_index"], "_id": id, "status": 201}
+				switch {
+				case strings.HasPrefix(id, "bad"):
+					item["status"] = 400
+					item["error"] = map[string]interface{}{"type": "mapper_parsing_exception", "reason": "failed to parse field"}
+				case strings.HasPrefix(id, "conflict"):
+					item["status"] = 409
+					item["error"] = map[string]interface{}{"type": "version_conflict_engine_exception", "reason": "version conflict"}
+				case strings.HasPrefix(id, "busy") && seen == 1:
+					item["status"] = 429
+					item["error"] = map[string]interface{}{"type": "es_rejected_execution_exception", "reason": "rejected execution"}
+				}
+				if item["status"] != 201 {
+					hasErrors = true
+				}
+				items = append(items, map[string]interface{}{action: item})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": hasErrors, "items": items})
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeBulkServer) Actions() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.actions...)
+}
+
+func newTestBulkClient(t *testing.T, url string, bulk *Bulk) *Client {
+	name := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+	// DefaultBulk的FlushInterval为1s，测试中放大定时flush间隔，由用例手动flush
+	if bulk.FlushInterval <= time.Second {
+		bulk.FlushInterval = 30 * time.Second
+	}
+	if err := InitClientWithOptions(name, []string{url}, "", "", WithBulk(bulk)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { RemoveClient(name) })
+	return MustGetClient(name)
+}
+
+func TestBulkDeadLetter(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	queue := NewMemoryDeadLetterQueue(0)
+	bulk := DefaultBulk()
+	bulk.DeadLetter = queue
+	c := newTestBulkClient(t, srv.URL, bulk)
+
+	c.BulkCreate("idx", "good-1", "r1", map[string]interface{}{"a": 1})
+	c.BulkCreate("idx", "bad-1", "r1", map[string]interface{}{"a": "x"})
+	c.BulkDelete("idx", "good-2", "", 3)
+	if err := c.BulkProcessor.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := queue.Drain()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Action != "create" || e.Index != "idx" || e.ID != "bad-1" || e.Routing != "r1" || e.Status != 400 ||
+		e.ErrorType != "mapper_parsing_exception" || string(e.Payload) != `{"a":"x"}` {
+		t.Fatalf("unexpected dead letter %+v", e)
+	}
+
+	path := filepath.Join(t.TempDir(), "dead.ndjson")
+	sink, err := NewFileDeadLetterSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Put(entries...); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	n, err := c.ReplayDeadLetterFile(path)
+	if err != nil || n != 1 {
+		t.Fatalf("replay: %d %v", n, err)
+	}
+	c.BulkProcessor.Flush()
+	actions := srv.Actions()
+	if actions[len(actions)-1] != "create:bad-1" {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	if queue.Len() != 1 {
+		t.Fatalf("expected replayed failure to be dead lettered again")
+	}
+}
+
+func (f *fakeBulkServer) Seen(id string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seen[id]
+}
@@ -63,7 +63,7 @@ func (c *Client) BulkCreate(indexName, id, routing string, doc interface{}) {
 	if len(routing) > 0 {
 		bulkCreateRequest.Routing(routing)
 	}
-	c.BulkProcessor.Add(bulkCreateRequest)
+	c.addBulkRequest(bulkCreateRequest)
 }
 
 func (c *Client) BulkCreateDocs(ctx context.Context, indexName string, docs []*BulkCreateDoc) (*elastic.BulkResponse, error) {
@@ -90,7 +90,7 @@ func (c *Client) BulkCreateWithVersion(ctx context.Context, indexName, id, routi
 	if len(routing) > 0 {
 		bulkCreateRequest.Routing(routing)
 	}
-	c.BulkProcessor.Add(bulkCreateRequest)
+	c.addBulkRequest(bulkCreateRequest)
 }
 
 func (c *Client) Delete(ctx context.Context, indexName, id, routing string) error {
@@ -135,7 +135,7 @@ func (c *Client) BulkDelete(indexName, id, routing string, version int64) {
 	if len(routing) > 0 {
 		bulkDeleteRequest.Routing(routing)
 	}
-	c.BulkProcessor.Add(bulkDeleteRequest)
+	c.addBulkRequest(bulkDeleteRequest)
 
 }
 
@@ -144,7 +144,7 @@ func (c *Client) BulkDeleteWithVersion(indexName, id, routing string, version in
 	if len(routing) > 0 {
 		bulkDeleteRequest.Routing(routing)
 	}
-	c.BulkProcessor.Add(bulkDeleteRequest)
+	c.addBulkRequest(bulkDeleteRequest)
 }
 
 func (c *Client) Update(ctx context.Context, indexName, id, routing string, update map[string]interface{}) error {
@@ -179,7 +179,7 @@ func (c *Client) BulkUpdate(indexName, id, routing string, update map[string]int
 	if len(routing) > 0 {
 		bulkService.Routing(routing)
 	}
-	c.BulkProcessor.Add(bulkService)
+	c.addBulkRequest(bulkService)
 }
 
 func (c *Client) BulkUpdateDocs(ctx context.Context, index string, updates []*BulkUpdateDoc) (*elastic.BulkResponse, error) {
@@ -217,7 +217,7 @@ func (c *Client) BulkUpsert(indexName, id, routing string, update map[string]int
 	if len(routing) > 0 {
 		bulkUpdateRequest.Routing(routing)
 	}
-	c.BulkProcessor.Add(bulkUpdateRequest)
+	c.addBulkRequest(bulkUpdateRequest)
 }
 
 // UpsertBulk 批量upsert
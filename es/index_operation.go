@@ -1,10 +1,15 @@
 package es
 
-import "context"
+import (
+	"context"
+	"errors"
+	"github.com/olivere/elastic/v7"
+)
 
+// IndexExists 判断索引或别名是否存在，forceCheck为false时优先使用CacheIndices
 func (c *Client) IndexExists(ctx context.Context, indexName string, forceCheck bool) (bool, error) {
 	if !forceCheck {
-		if _, ok := c.CacheIndices.Load(indexName); !ok {
+		if _, ok := c.CacheIndices.Load(indexName); ok {
 			return true, nil
 		}
 	}
@@ -15,19 +20,30 @@ func (c *Client) IndexExists(ctx context.Context, indexName string, forceCheck b
 	return exists, err
 }
 
+// CreateIndex 索引不存在时创建，已存在(包括被其他进程并发创建)时返回nil
 func (c *Client) CreateIndex(ctx context.Context, indexName, bodyJson string, forceCheck bool) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	exists, err := c.IndexExists(ctx, indexName, forceCheck)
 	if err != nil {
-		return nil
+		return err
 	}
 	if exists {
 		return nil
 	}
-	_, err = c.Client.CreateIndex(indexName).BodyJson(bodyJson).Do(ctx)
-	if err != nil {
-		c.CacheIndices.Store(indexName, true)
+	createService := c.Client.CreateIndex(indexName)
+	if len(bodyJson) > 0 {
+		createService.BodyString(bodyJson)
 	}
-	return err
+	_, err = createService.Do(ctx)
+	if err != nil && !isResourceAlreadyExists(err) {
+		return err
+	}
+	c.CacheIndices.Store(indexName, true)
+	return nil
+}
+
+func isResourceAlreadyExists(err error) bool {
+	var e *elastic.Error
+	return errors.As(err, &e) && e.Details != nil && e.Details.Type == "resource_already_exists_exception"
 }
@@ -0,0 +1,130 @@
+package es
+
+import (
+	"awesomeProject/timeutil"
+	"context"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"strings"
+	"time"
+)
+
+type PartitionInterval int
+
+const (
+	PartitionDaily   PartitionInterval = iota //events-20261017
+	PartitionWeekly                           //events-20261012，后缀为周一的日期
+	PartitionMonthly                          //events-202610
+)
+
+// maxExpandedIndices 时间范围展开后的索引数超过该值时改用通配符，避免URL过长
+const maxExpandedIndices = 200
+
+// IndexPattern 按时间分区的索引，索引名为Prefix加上分区的起始日期
+type IndexPattern struct {
+	Prefix   string
+	Interval PartitionInterval
+	Body     string //自动创建索引时使用的settings和mappings
+}
+
+func NewIndexPattern(prefix string, interval PartitionInterval, body string) *IndexPattern {
+	return &IndexPattern{Prefix: prefix, Interval: interval, Body: body}
+}
+
+// start 时间t所在分区的起始时间
+func (p *IndexPattern) start(t time.Time) time.Time {
+	switch p.Interval {
+	case PartitionWeekly:
+		return timeutil.StartOfWeek(t)
+	case PartitionMonthly:
+		return timeutil.StartOfMonth(t)
+	default:
+		return timeutil.StartOfDay(t)
+	}
+}
+
+// next 下一个分区的起始时间
+func (p *IndexPattern) next(start time.Time) time.Time {
+	switch p.Interval {
+	case PartitionWeekly:
+		return start.AddDate(0, 0, 7)
+	case PartitionMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Index 时间t所在分区的索引名
+func (p *IndexPattern) Index(t time.Time) string {
+	if p.Interval == PartitionMonthly {
+		return p.Prefix + timeutil.YMLayoutString(t)
+	}
+	return p.Prefix + timeutil.YMDLayoutString(p.start(t))
+}
+
+// Parse 从索引名解析分区的起始时间，不属于该pattern的索引返回false
+func (p *IndexPattern) Parse(indexName string) (time.Time, bool) {
+	if !strings.HasPrefix(indexName, p.Prefix) {
+		return time.Time{}, false
+	}
+	suffix := strings.TrimPrefix(indexName, p.Prefix)
+	var (
+		t   time.Time
+		err error
+	)
+	if p.Interval == PartitionMonthly {
+		t, err = timeutil.ParseYMInLocation(suffix)
+	} else {
+		t, err = timeutil.ParseYMDInLocation(suffix)
+	}
+	//events-2026101的后缀也能被解析，要求格式化后与原索引名完全一致
+	if err != nil || p.Index(t) != indexName {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Wildcard 匹配全部分区的通配符
+func (p *IndexPattern) Wildcard() string {
+	return p.Prefix + "*"
+}
+
+// Indices 与[from, to]有交集的全部分区索引
+func (p *IndexPattern) Indices(from, to time.Time) []string {
+	if to.Before(from) {
+		return []string{}
+	}
+	indices := make([]string, 0)
+	for start := p.start(from); !start.After(to); start = p.next(start) {
+		indices = append(indices, p.Index(start))
+	}
+	return indices
+}
+
+// IndexNames 查询[from, to]时使用的索引，分区过多时退化为通配符
+func (p *IndexPattern) IndexNames(from, to time.Time) string {
+	indices := p.Indices(from, to)
+	if len(indices) > maxExpandedIndices {
+		return p.Wildcard()
+	}
+	return strings.Join(indices, ",")
+}
+
+// PatternIndex 返回时间t对应的写入索引，索引不存在时使用pattern.Body创建，结果记录在CacheIndices中
+func (c *Client) PatternIndex(ctx context.Context, pattern *IndexPattern, t time.Time) (string, error) {
+	indexName := pattern.Index(t)
+	if err := c.CreateIndex(ctx, indexName, pattern.Body, false); err != nil {
+		return "", fmt.Errorf("es: create index %s: %w", indexName, err)
+	}
+	return indexName, nil
+}
+
+// QueryRange 只查询[from, to]范围内的分区，时间字段的range条件仍需要包含在query中
+func (c *Client) QueryRange(ctx context.Context, pattern *IndexPattern, from, to time.Time, routes []string, query elastic.Query, offset, size int, options ...QueryOption) (*elastic.SearchResult, error) {
+	indexNames := pattern.IndexNames(from, to)
+	if len(indexNames) == 0 {
+		return nil, fmt.Errorf("es: empty time range %s - %s", from, to)
+	}
+	return c.Query(ctx, indexNames, routes, query, offset, size, options...)
+}
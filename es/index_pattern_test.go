@@ -0,0 +1,100 @@
+package es
+
+import (
+	"awesomeProject/timeutil"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIndexPattern(t *testing.T) {
+	ts, _ := timeutil.ParseCSTInLcation("2026-10-17 23:30:00")
+	cases := []struct {
+		pattern *IndexPattern
+		index   string
+		window  string
+	}{
+		{NewIndexPattern("events-", PartitionDaily, ""), "events-20261017", "events-20261016,events-20261017"},
+		{NewIndexPattern("events-", PartitionWeekly, ""), "events-20261012", "events-20261012"},
+		{NewIndexPattern("events-", PartitionMonthly, ""), "events-202610", "events-202610"},
+	}
+	for _, tc := range cases {
+		if index := tc.pattern.Index(ts); index != tc.index {
+			t.Fatalf("expected %s, got %s", tc.index, index)
+		}
+		start, ok := tc.pattern.Parse(tc.index)
+		if !ok || tc.pattern.Index(start) != tc.index || start.After(ts) {
+			t.Fatalf("unexpected parse result %s %v", start, ok)
+		}
+		if window := tc.pattern.IndexNames(ts.Add(-24*time.Hour), ts); window != tc.window {
+			t.Fatalf("expected window %s, got %s", tc.window, window)
+		}
+	}
+
+	daily := NewIndexPattern("events-", PartitionDaily, "")
+	for _, name := range []string{"events-2026101", "events-20261017-old", "logs-20261017", "events-latest"} {
+		if _, ok := daily.Parse(name); ok {
+			t.Fatalf("expected %s not to match", name)
+		}
+	}
+	if _, ok := NewIndexPattern("events-", PartitionWeekly, "").Parse("events-20261017"); ok {
+		t.Fatal("expected weekly pattern to reject a date that is not a monday")
+	}
+	if daily.IndexNames(ts.AddDate(-2, 0, 0), ts) != "events-*" {
+		t.Fatal("expected wildcard for a long time range")
+	}
+}
+
+func TestPatternIndexCreatesOnce(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		heads    int
+		creates  []string
+		searched string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/":
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodHead:
+			heads++
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodPut:
+			creates = append(creates, r.URL.Path)
+			//模拟其他进程已经创建了索引
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"type":"resource_already_exists_exception","reason":"index already exists"},"status":400}`))
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			searched = r.URL.Path
+			w.Write([]byte(`{"hits":{"hits":[]}}`))
+		}
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	pattern := NewIndexPattern("events-", PartitionDaily, `{"settings":{"number_of_shards":1}}`)
+	ts, _ := timeutil.ParseCSTInLcation("2026-10-17 08:00:00")
+
+	for i := 0; i < 3; i++ {
+		index, err := c.PatternIndex(context.Background(), pattern, ts.Add(time.Duration(i)*time.Hour))
+		if err != nil || index != "events-20261017" {
+			t.Fatalf("unexpected index %s %v", index, err)
+		}
+	}
+	if heads != 1 || len(creates) != 1 {
+		t.Fatalf("expected a single existence check and create, got %d %v", heads, creates)
+	}
+
+	if _, err := c.QueryRange(context.Background(), pattern, ts.AddDate(0, 0, -2), ts, nil, nil, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if searched != "/events-20261015,events-20261016,events-20261017/_search" {
+		t.Fatalf("unexpected search path %s", searched)
+	}
+}
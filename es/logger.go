@@ -0,0 +1,135 @@
+package es
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"strings"
+	"sync/atomic"
+)
+
+// Field 结构化日志字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Logger 分级、结构化的日志接口
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+}
+
+var defaultLogger atomic.Value
+
+// SetLogger 设置包级别的默认Logger，未通过WithLogger单独设置的Client都会使用它
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NewStdLogger(nil)
+	}
+	defaultLogger.Store(&loggerHolder{logger})
+}
+
+type loggerHolder struct {
+	Logger
+}
+
+func getLogger() Logger {
+	if h, ok := defaultLogger.Load().(*loggerHolder); ok {
+		return h.Logger
+	}
+	return NewStdLogger(nil)
+}
+
+// WithLogger 为单个Client设置Logger
+func WithLogger(logger Logger) Option {
+	return func(o *option) {
+		o.Logger = logger
+	}
+}
+
+func (c *Client) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return getLogger()
+}
+
+type stdLoggerAdapter struct {
+	logger stdLogger
+}
+
+// NewStdLogger 将stdLogger适配为Logger，logger为nil时使用EStdLogger
+func NewStdLogger(logger stdLogger) Logger {
+	return &stdLoggerAdapter{logger: logger}
+}
+
+func (l *stdLoggerAdapter) output(level, msg string, fields []Field) {
+	var sb strings.Builder
+	sb.WriteString(level)
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for _, f := range fields {
+		fmt.Fprintf(&sb, " %s=%v", f.Key, f.Value)
+	}
+	logger := l.logger
+	if logger == nil {
+		logger = EStdLogger
+	}
+	if o, ok := logger.(interface{ Output(int, string) error }); ok {
+		o.Output(3, sb.String())
+		return
+	}
+	logger.Print(sb.String())
+}
+
+func (l *stdLoggerAdapter) Debug(msg string, fields ...Field) { l.output("DEBUG", msg, fields) }
+func (l *stdLoggerAdapter) Info(msg string, fields ...Field)  { l.output("INFO", msg, fields) }
+func (l *stdLoggerAdapter) Warn(msg string, fields ...Field)  { l.output("WARN", msg, fields) }
+func (l *stdLoggerAdapter) Error(msg string, fields ...Field) { l.output("ERROR", msg, fields) }
+
+type zapLogger struct {
+	logger *zap.Logger
+}
+
+// NewZapLogger 将zap.Logger适配为Logger
+func NewZapLogger(logger *zap.Logger) Logger {
+	return &zapLogger{logger: logger.WithOptions(zap.AddCallerSkip(1))}
+}
+
+func zapFields(fields []Field) []zap.Field {
+	zfs := make([]zap.Field, 0, len(fields))
+	for _, f := range fields {
+		zfs = append(zfs, zap.Any(f.Key, f.Value))
+	}
+	return zfs
+}
+
+func (l *zapLogger) Debug(msg string, fields ...Field) { l.logger.Debug(msg, zapFields(fields)...) }
+func (l *zapLogger) Info(msg string, fields ...Field)  { l.logger.Info(msg, zapFields(fields)...) }
+func (l *zapLogger) Warn(msg string, fields ...Field)  { l.logger.Warn(msg, zapFields(fields)...) }
+func (l *zapLogger) Error(msg string, fields ...Field) { l.logger.Error(msg, zapFields(fields)...) }
+
+// elasticLogger 将Logger适配为elastic.Logger，用于SDK内部的error/info日志
+type elasticLogger struct {
+	logger Logger
+	error  bool
+}
+
+func (l *elasticLogger) Printf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if l.error {
+		l.logger.Error(msg)
+		return
+	}
+	l.logger.Info(msg)
+}
@@ -0,0 +1,46 @@
+package es
+
+import (
+	"bytes"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := NewZapLogger(zap.New(core))
+	logger.Warn("slow query", Any("index", "idx"), Any("took_ms", int64(12)))
+
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Level != zapcore.WarnLevel {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	fields := entries[0].ContextMap()
+	if fields["index"] != "idx" || fields["took_ms"] != int64(12) {
+		t.Fatalf("unexpected fields %+v", fields)
+	}
+}
+
+func TestStdLoggerAndClientOverride(t *testing.T) {
+	buf := &bytes.Buffer{}
+	SetLogger(NewStdLogger(log.New(buf, "", 0)))
+	defer SetLogger(nil)
+
+	c := &Client{}
+	c.logger().Error("bulk request failed", Any("actions", 3), Err(errors.New("boom")))
+	if got := strings.TrimSpace(buf.String()); got != "ERROR bulk request failed actions=3 error=boom" {
+		t.Fatalf("unexpected output %q", got)
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	c.Logger = NewZapLogger(zap.New(core))
+	c.logger().Info("es query")
+	if logs.Len() != 1 {
+		t.Fatalf("expected client logger to be used")
+	}
+}
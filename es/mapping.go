@@ -0,0 +1,435 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mapping 索引的mappings
+type Mapping struct {
+	Dynamic    interface{}              `json:"dynamic,omitempty"`
+	Properties map[string]*MappingField `json:"properties"`
+}
+
+// MappingField 单个字段的mapping，object类型不设置Type，与ES返回的格式一致
+type MappingField struct {
+	Type           string                   `json:"type,omitempty"`
+	Analyzer       string                   `json:"analyzer,omitempty"`
+	SearchAnalyzer string                   `json:"search_analyzer,omitempty"`
+	Index          *bool                    `json:"index,omitempty"`
+	DocValues      *bool                    `json:"doc_values,omitempty"`
+	Format         string                   `json:"format,omitempty"`
+	IgnoreAbove    int                      `json:"ignore_above,omitempty"`
+	Properties     map[string]*MappingField `json:"properties,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// GenerateMapping 根据结构体生成mapping，字段名取json tag，es tag设置mapping参数，例如
+//
+//	type Doc struct {
+//		Title   string    `json:"title" es:"type=text,analyzer=ik_max_word,search_analyzer=ik_smart"`
+//		Tags    []string  `json:"tags"`
+//		Raw     string    `json:"raw" es:"index=false"`
+//		Created time.Time `json:"created" es:"format=epoch_millis"`
+//		Items   []Item    `json:"items" es:"type=nested"`
+//		Ignored string    `json:"ignored" es:"-"`
+//	}
+//
+// 未指定type时：string为keyword，整数为long/integer/short/byte，浮点数为double/float，bool为boolean，
+// time.Time为date，结构体为object，切片和指针取元素类型，map为object
+func GenerateMapping(doc interface{}) (*Mapping, error) {
+	t := reflect.TypeOf(doc)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("es: mapping requires a struct, got %v", reflect.TypeOf(doc))
+	}
+	properties, err := structProperties(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return &Mapping{Properties: properties}, nil
+}
+
+// JSON mappings部分
+func (m *Mapping) JSON() (string, error) {
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// IndexBody 创建索引的完整body，可以直接传给CreateIndex
+func (m *Mapping) IndexBody(settings map[string]interface{}) (string, error) {
+	body := map[string]interface{}{"mappings": m}
+	if len(settings) > 0 {
+		body["settings"] = settings
+	}
+	data, err := json.Marshal(body)
+	return string(data), err
+}
+
+func structProperties(t reflect.Type, visiting map[reflect.Type]bool) (map[string]*MappingField, error) {
+	if visiting[t] {
+		return nil, fmt.Errorf("es: recursive type %s can not be mapped", t)
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	properties := make(map[string]*MappingField)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, skip := jsonFieldName(sf)
+		if skip {
+			continue
+		}
+		tag := sf.Tag.Get("es")
+		if tag == "-" {
+			continue
+		}
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		//没有json名称的匿名结构体字段与encoding/json一样展开到上一层
+		if sf.Anonymous && sf.Tag.Get("json") == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			embedded, err := structProperties(ft, visiting)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range embedded {
+				if _, ok := properties[k]; !ok {
+					properties[k] = v
+				}
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		field, err := fieldMapping(sf.Type, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("es: field %s.%s: %w", t.Name(), sf.Name, err)
+		}
+		if field, err = applyFieldTag(field, tag); err != nil {
+			return nil, fmt.Errorf("es: field %s.%s: %w", t.Name(), sf.Name, err)
+		}
+		properties[name] = field
+	}
+	return properties, nil
+}
+
+// jsonFieldName 与encoding/json的规则一致
+func jsonFieldName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	name := strings.Split(tag, ",")[0]
+	if len(name) == 0 {
+		name = sf.Name
+	}
+	return name, false
+}
+
+// fieldMapping 根据类型推断mapping，无法推断(interface{})时返回nil，需要通过tag指定type
+func fieldMapping(t reflect.Type, visiting map[reflect.Type]bool) (*MappingField, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &MappingField{Type: "date"}, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &MappingField{Type: "keyword"}, nil
+	case reflect.Bool:
+		return &MappingField{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &MappingField{Type: "long"}, nil
+	case reflect.Int32, reflect.Uint16:
+		return &MappingField{Type: "integer"}, nil
+	case reflect.Int16, reflect.Uint8:
+		return &MappingField{Type: "short"}, nil
+	case reflect.Int8:
+		return &MappingField{Type: "byte"}, nil
+	case reflect.Float64:
+		return &MappingField{Type: "double"}, nil
+	case reflect.Float32:
+		return &MappingField{Type: "float"}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &MappingField{Type: "binary"}, nil
+		}
+		return fieldMapping(t.Elem(), visiting)
+	case reflect.Map:
+		return &MappingField{Type: "object"}, nil
+	case reflect.Struct:
+		properties, err := structProperties(t, visiting)
+		if err != nil {
+			return nil, err
+		}
+		return &MappingField{Properties: properties}, nil
+	}
+	return nil, nil
+}
+
+// applyFieldTag 解析es tag，field为nil时只能通过tag指定type
+func applyFieldTag(field *MappingField, tag string) (*MappingField, error) {
+	if len(tag) == 0 {
+		if field == nil {
+			return nil, fmt.Errorf("can not infer type, add es:\"type=...\" or es:\"-\"")
+		}
+		return field, nil
+	}
+	if field == nil {
+		field = &MappingField{}
+	}
+	for _, item := range strings.Split(tag, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid es tag %q", item)
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "type":
+			field.Type = value
+			//object、nested以外的类型没有properties
+			if value != "object" && value != "nested" {
+				field.Properties = nil
+			}
+		case "analyzer":
+			field.Analyzer = value
+		case "search_analyzer":
+			field.SearchAnalyzer = value
+		case "format":
+			field.Format = value
+		case "index", "doc_values":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", key, value)
+			}
+			if key == "index" {
+				field.Index = &b
+			} else {
+				field.DocValues = &b
+			}
+		case "ignore_above":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ignore_above %q", value)
+			}
+			field.IgnoreAbove = n
+		default:
+			return nil, fmt.Errorf("unknown es tag %q", key)
+		}
+	}
+	if len(field.Type) == 0 && field.Properties == nil {
+		return nil, fmt.Errorf("can not infer type, add es:\"type=...\" or es:\"-\"")
+	}
+	return field, nil
+}
+
+// MappingDiff 期望的mapping与ES中mapping的差异，字段使用点分隔的路径
+type MappingDiff struct {
+	Added        []string //新增字段，可以通过PutMapping追加
+	Changed      []string //可以在线修改的参数，例如search_analyzer、ignore_above
+	Incompatible []string //类型、分词器等无法修改的参数，只能重建索引
+	Removed      []string //ES中存在但结构体中没有的字段，ES不支持删除字段
+}
+
+// Compatible 是否可以不重建索引直接更新mapping
+func (d *MappingDiff) Compatible() bool {
+	return len(d.Incompatible) == 0
+}
+
+// DiffMapping 比较ES中的live与期望的desired
+func DiffMapping(live, desired *Mapping) *MappingDiff {
+	diff := &MappingDiff{Added: []string{}, Changed: []string{}, Incompatible: []string{}, Removed: []string{}}
+	var liveProps, desiredProps map[string]*MappingField
+	if live != nil {
+		liveProps = live.Properties
+	}
+	if desired != nil {
+		desiredProps = desired.Properties
+	}
+	diffProperties("", liveProps, desiredProps, diff)
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Incompatible)
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+func diffProperties(prefix string, live, desired map[string]*MappingField, diff *MappingDiff) {
+	for name, want := range desired {
+		path := joinPath(prefix, name)
+		have, ok := live[name]
+		if !ok {
+			diff.Added = append(diff.Added, path)
+			continue
+		}
+		if have.kind() != want.kind() {
+			diff.Incompatible = append(diff.Incompatible, fmt.Sprintf("%s: type %s -> %s", path, have.kind(), want.kind()))
+			continue
+		}
+		if have.Analyzer != want.Analyzer {
+			diff.Incompatible = append(diff.Incompatible, fmt.Sprintf("%s: analyzer %q -> %q", path, have.Analyzer, want.Analyzer))
+		}
+		if have.Format != want.Format && len(want.Format) > 0 {
+			diff.Incompatible = append(diff.Incompatible, fmt.Sprintf("%s: format %q -> %q", path, have.Format, want.Format))
+		}
+		if boolValue(have.Index, true) != boolValue(want.Index, true) {
+			diff.Incompatible = append(diff.Incompatible, fmt.Sprintf("%s: index %v -> %v", path, boolValue(have.Index, true), boolValue(want.Index, true)))
+		}
+		if boolValue(have.DocValues, true) != boolValue(want.DocValues, true) {
+			diff.Incompatible = append(diff.Incompatible, fmt.Sprintf("%s: doc_values %v -> %v", path, boolValue(have.DocValues, true), boolValue(want.DocValues, true)))
+		}
+		if have.SearchAnalyzer != want.SearchAnalyzer {
+			diff.Changed = append(diff.Changed, fmt.Sprintf("%s: search_analyzer %q -> %q", path, have.SearchAnalyzer, want.SearchAnalyzer))
+		}
+		if have.IgnoreAbove != want.IgnoreAbove {
+			diff.Changed = append(diff.Changed, fmt.Sprintf("%s: ignore_above %d -> %d", path, have.IgnoreAbove, want.IgnoreAbove))
+		}
+		diffProperties(path, have.Properties, want.Properties, diff)
+	}
+	for name := range live {
+		if _, ok := desired[name]; !ok {
+			diff.Removed = append(diff.Removed, joinPath(prefix, name))
+		}
+	}
+}
+
+// kind ES返回的object字段没有type
+func (f *MappingField) kind() string {
+	if len(f.Type) == 0 {
+		return "object"
+	}
+	return f.Type
+}
+
+func boolValue(b *bool, defaultValue bool) bool {
+	if b == nil {
+		return defaultValue
+	}
+	return *b
+}
+
+// GetMapping 返回索引的mapping，indexName为别名时要求别名下所有索引的mapping一致
+func (c *Client) GetMapping(ctx context.Context, indexName string) (*Mapping, error) {
+	mapping, _, err := c.getMapping(ctx, indexName)
+	return mapping, err
+}
+
+// getMapping 同时返回ES原始的mappings，保留MappingField中没有的参数，例如fields、copy_to、norms
+func (c *Client) getMapping(ctx context.Context, indexName string) (*Mapping, map[string]interface{}, error) {
+	res, err := c.Client.GetMapping().Index(indexName).Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		mapping *Mapping
+		raw     map[string]interface{}
+		first   []byte
+	)
+	for index, value := range res {
+		indexMapping, _ := value.(map[string]interface{})
+		data, err := json.Marshal(indexMapping["mappings"])
+		if err != nil {
+			return nil, nil, err
+		}
+		if first != nil && string(first) != string(data) {
+			return nil, nil, fmt.Errorf("es: indices behind %s have different mappings, found in %s", indexName, index)
+		}
+		first = data
+		mapping = &Mapping{}
+		if err = json.Unmarshal(data, mapping); err != nil {
+			return nil, nil, err
+		}
+		raw, _ = indexMapping["mappings"].(map[string]interface{})
+	}
+	if mapping == nil {
+		return nil, nil, fmt.Errorf("es: no mapping found for %s", indexName)
+	}
+	return mapping, raw, nil
+}
+
+// DiffMapping 比较期望的mapping与ES中的mapping
+func (c *Client) DiffMapping(ctx context.Context, indexName string, desired *Mapping) (*MappingDiff, error) {
+	live, err := c.GetMapping(ctx, indexName)
+	if err != nil {
+		return nil, err
+	}
+	return DiffMapping(live, desired), nil
+}
+
+// PutMapping 更新mapping，存在不兼容的修改时返回错误，需要通过BlueGreenReindex重建索引。
+// 只提交新增和修改的字段，修改的字段以ES中的原始mapping为基础，避免丢失fields、copy_to等未建模的参数
+func (c *Client) PutMapping(ctx context.Context, indexName string, desired *Mapping) (*MappingDiff, error) {
+	live, raw, err := c.getMapping(ctx, indexName)
+	if err != nil {
+		return nil, err
+	}
+	diff := DiffMapping(live, desired)
+	if !diff.Compatible() {
+		return diff, fmt.Errorf("es: incompatible mapping changes on %s: %s", indexName, strings.Join(diff.Incompatible, "; "))
+	}
+	if len(diff.Added) == 0 && len(diff.Changed) == 0 {
+		return diff, nil
+	}
+	rawProperties, _ := raw["properties"].(map[string]interface{})
+	body := map[string]interface{}{"properties": mappingUpdate(rawProperties, live.Properties, desired.Properties)}
+	_, err = c.Client.PutMapping().Index(indexName).BodyJson(body).Do(ctx)
+	return diff, err
+}
+
+// mappingUpdate 生成PutMapping的properties，只包含新增字段、修改了search_analyzer或ignore_above的字段及其上级字段
+func mappingUpdate(raw map[string]interface{}, live, desired map[string]*MappingField) map[string]interface{} {
+	update := make(map[string]interface{})
+	for name, want := range desired {
+		have, ok := live[name]
+		if !ok {
+			update[name] = want
+			continue
+		}
+		rawField, _ := raw[name].(map[string]interface{})
+		var field map[string]interface{}
+		if have.SearchAnalyzer != want.SearchAnalyzer || have.IgnoreAbove != want.IgnoreAbove {
+			field = make(map[string]interface{}, len(rawField))
+			for k, v := range rawField {
+				if k != "properties" {
+					field[k] = v
+				}
+			}
+			delete(field, "search_analyzer")
+			delete(field, "ignore_above")
+			if len(want.SearchAnalyzer) > 0 {
+				field["search_analyzer"] = want.SearchAnalyzer
+			}
+			if want.IgnoreAbove > 0 {
+				field["ignore_above"] = want.IgnoreAbove
+			}
+		}
+		rawProperties, _ := rawField["properties"].(map[string]interface{})
+		if children := mappingUpdate(rawProperties, have.Properties, want.Properties); len(children) > 0 {
+			if field == nil {
+				field = make(map[string]interface{})
+				//nested字段省略type会被当作object，无法合并
+				if t, ok := rawField["type"]; ok {
+					field["type"] = t
+				}
+			}
+			field["properties"] = children
+		}
+		if field != nil {
+			update[name] = field
+		}
+	}
+	return update
+}
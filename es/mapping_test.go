@@ -0,0 +1,169 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mappingBase struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created" es:"format=epoch_millis"`
+}
+
+type mappingComment struct {
+	Author string `json:"author"`
+	Likes  int32  `json:"likes"`
+}
+
+type mappingDoc struct {
+	mappingBase
+	Title    string            `json:"title" es:"type=text,analyzer=ik_max_word,search_analyzer=ik_smart"`
+	Tags     []string          `json:"tags,omitempty"`
+	Raw      string            `json:"raw" es:"index=false"`
+	Score    *float64          `json:"score"`
+	Deleted  bool              `json:"deleted"`
+	Updated  *time.Time        `json:"updated"`
+	Comments []*mappingComment `json:"comments" es:"type=nested"`
+	Author   mappingComment    `json:"author"`
+	Labels   map[string]string `json:"labels"`
+	Payload  interface{}       `json:"payload" es:"type=object"`
+	Skip     string            `json:"-"`
+	Ignored  string            `json:"ignored" es:"-"`
+	internal string
+}
+
+func TestGenerateMapping(t *testing.T) {
+	mapping, err := GenerateMapping(&mappingDoc{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := mapping.JSON()
+	expected := `{"properties":{"author":{"properties":{"author":{"type":"keyword"},"likes":{"type":"integer"}}},` +
+		`"comments":{"type":"nested","properties":{"author":{"type":"keyword"},"likes":{"type":"integer"}}},` +
+		`"created":{"type":"date","format":"epoch_millis"},"deleted":{"type":"boolean"},"id":{"type":"keyword"},` +
+		`"labels":{"type":"object"},"payload":{"type":"object"},"raw":{"type":"keyword","index":false},"score":{"type":"double"},` +
+		`"tags":{"type":"keyword"},"title":{"type":"text","analyzer":"ik_max_word","search_analyzer":"ik_smart"},"updated":{"type":"date"}}}`
+	if data != expected {
+		t.Fatalf("unexpected mapping\n%s\n%s", data, expected)
+	}
+
+	type bad struct {
+		Any interface{} `json:"any"`
+	}
+	if _, err = GenerateMapping(bad{}); err == nil {
+		t.Fatal("expected error for interface field without type")
+	}
+	type badTag struct {
+		Name string `json:"name" es:"tokenizer=x"`
+	}
+	if _, err = GenerateMapping(badTag{}); err == nil {
+		t.Fatal("expected error for unknown tag")
+	}
+}
+
+func TestDiffMapping(t *testing.T) {
+	live := `{"idx":{"mappings":{"properties":{
+		"title":{"type":"text","analyzer":"standard"},
+		"id":{"type":"keyword"},
+		"created":{"type":"date","format":"epoch_millis"},
+		"author":{"properties":{"author":{"type":"keyword"},"likes":{"type":"long"}}},
+		"legacy":{"type":"keyword"}}}}}`
+	var put string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(live))
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			put = string(body)
+			w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	desired, _ := GenerateMapping(mappingDoc{})
+	diff, err := c.PutMapping(context.Background(), "idx", desired)
+	if err == nil || put != "" {
+		t.Fatalf("expected incompatible changes to be rejected, got %v", err)
+	}
+	if fmt.Sprint(diff.Incompatible) != `[author.likes: type long -> integer title: analyzer "standard" -> "ik_max_word"]` ||
+		fmt.Sprint(diff.Removed) != "[legacy]" || fmt.Sprint(diff.Changed) != `[title: search_analyzer "" -> "ik_smart"]` || len(diff.Added) != 8 {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+
+	type v2 struct {
+		ID      string    `json:"id"`
+		Created time.Time `json:"created" es:"format=epoch_millis"`
+		Title   string    `json:"title" es:"type=text,analyzer=standard"`
+		Summary string    `json:"summary" es:"type=text"`
+	}
+	desired, _ = GenerateMapping(v2{})
+	if diff, err = c.PutMapping(context.Background(), "idx", desired); err != nil || fmt.Sprint(diff.Added) != "[summary]" {
+		t.Fatalf("unexpected compatible diff %+v %v", diff, err)
+	}
+	body := &Mapping{}
+	if err = json.Unmarshal([]byte(put), body); err != nil || body.Properties["summary"].Type != "text" {
+		t.Fatalf("unexpected put mapping body %s", put)
+	}
+}
+
+func TestPutMappingKeepsUnmodeledParams(t *testing.T) {
+	live := `{"idx":{"mappings":{"properties":{
+		"name":{"type":"keyword","ignore_above":256,"copy_to":"all","fields":{"raw":{"type":"keyword","normalizer":"lowercase"}}},
+		"body":{"type":"text","norms":false,"fields":{"en":{"type":"text","analyzer":"english"}}},
+		"comments":{"type":"nested","properties":{"author":{"type":"keyword"}}},
+		"all":{"type":"text"}}}}}`
+	var put string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(live))
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			put = string(body)
+			w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	type comment struct {
+		Author string `json:"author"`
+		Likes  int32  `json:"likes"`
+	}
+	type doc struct {
+		Name     string     `json:"name" es:"ignore_above=512"`
+		Body     string     `json:"body" es:"type=text"`
+		Comments []*comment `json:"comments" es:"type=nested"`
+		All      string     `json:"all" es:"type=text"`
+		Summary  string     `json:"summary" es:"type=text"`
+	}
+	desired, _ := GenerateMapping(doc{})
+	diff, err := c.PutMapping(context.Background(), "idx", desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(diff.Added) != "[comments.likes summary]" || fmt.Sprint(diff.Changed) != "[name: ignore_above 256 -> 512]" {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+	want := `{"properties":{` +
+		`"comments":{"properties":{"likes":{"type":"integer"}},"type":"nested"},` +
+		`"name":{"copy_to":"all","fields":{"raw":{"normalizer":"lowercase","type":"keyword"}},"ignore_above":512,"type":"keyword"},` +
+		`"summary":{"type":"text"}}}`
+	if put != want {
+		t.Fatalf("unexpected put mapping body\n%s\nwant\n%s", put, want)
+	}
+}
@@ -0,0 +1,332 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultMigrationIndex 保存已执行版本和锁的元数据索引
+const DefaultMigrationIndex = ".es_migrations"
+
+const migrationIndexBody = `{"settings":{"number_of_shards":1,"auto_expand_replicas":"0-1"},"mappings":{"dynamic":"strict","properties":{
+"target":{"type":"keyword"},"kind":{"type":"keyword"},"version":{"type":"long"},"description":{"type":"keyword","index":false},
+"owner":{"type":"keyword"},"time":{"type":"date"},"expires":{"type":"date"},"took_ms":{"type":"long"}}}}`
+
+var ErrMigrationLocked = errors.New("es: migration is locked by another instance")
+
+// Migration 一个版本的变更，Up在迁移目标(索引或别名)上执行，需要幂等，
+// 执行成功后才会记录版本，Up中途失败时下次会重新执行
+type Migration struct {
+	Version     int64
+	Description string
+	Up          func(ctx context.Context, c *Client, target string) error
+}
+
+// AddFieldsMigration 追加mapping字段，存在不兼容的修改时失败
+func AddFieldsMigration(version int64, description string, mapping *Mapping) Migration {
+	return Migration{Version: version, Description: description, Up: func(ctx context.Context, c *Client, target string) error {
+		_, err := c.PutMapping(ctx, target, mapping)
+		return err
+	}}
+}
+
+// UpdateSettingsMigration 更新索引的动态settings，例如{"index":{"refresh_interval":"5s"}}
+func UpdateSettingsMigration(version int64, description string, settings map[string]interface{}) Migration {
+	return Migration{Version: version, Description: description, Up: func(ctx context.Context, c *Client, target string) error {
+		_, err := c.Client.IndexPutSettings(target).BodyJson(settings).Do(ctx)
+		return err
+	}}
+}
+
+// PutPipelineMigration 创建或更新ingest pipeline
+func PutPipelineMigration(version int64, description, pipelineID, body string) Migration {
+	return Migration{Version: version, Description: description, Up: func(ctx context.Context, c *Client, target string) error {
+		_, err := c.Client.IngestPutPipeline(pipelineID).BodyString(body).Do(ctx)
+		return err
+	}}
+}
+
+// MigrationStep 迁移计划中的一个版本
+type MigrationStep struct {
+	Version     int64
+	Description string
+	Applied     bool
+	AppliedAt   time.Time
+}
+
+func (s *MigrationStep) String() string {
+	state := "pending"
+	if s.Applied {
+		state = "applied " + s.AppliedAt.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%d %s (%s)", s.Version, s.Description, state)
+}
+
+// Migrator 按版本顺序对一个索引或别名执行Migration，同一个target同一时间只有一个实例在执行
+type Migrator struct {
+	client     *Client
+	target     string
+	index      string
+	owner      string
+	lockTTL    time.Duration
+	migrations []Migration
+}
+
+type MigratorOption func(m *Migrator)
+
+func WithMigrationIndex(index string) MigratorOption {
+	return func(m *Migrator) {
+		m.index = index
+	}
+}
+
+// WithMigrationLockTTL 锁的有效期，持有锁的实例崩溃后超过该时间其他实例可以抢占。
+// 执行Up期间每隔TTL/3续期一次，续期失败时会取消正在执行的Up的ctx
+func WithMigrationLockTTL(ttl time.Duration) MigratorOption {
+	return func(m *Migrator) {
+		m.lockTTL = ttl
+	}
+}
+
+// WithMigrationOwner 锁的持有者标识，默认为hostname-pid
+func WithMigrationOwner(owner string) MigratorOption {
+	return func(m *Migrator) {
+		m.owner = owner
+	}
+}
+
+func NewMigrator(client *Client, target string, migrations []Migration, options ...MigratorOption) (*Migrator, error) {
+	hostname, _ := os.Hostname()
+	m := &Migrator{
+		client:  client,
+		target:  target,
+		index:   DefaultMigrationIndex,
+		owner:   fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		lockTTL: 10 * time.Minute,
+	}
+	for _, f := range options {
+		if f != nil {
+			f(m)
+		}
+	}
+	if m.lockTTL <= 0 {
+		return nil, fmt.Errorf("es: migration lock ttl must be positive, got %s", m.lockTTL)
+	}
+	m.migrations = append([]Migration(nil), migrations...)
+	sort.Slice(m.migrations, func(i, j int) bool { return m.migrations[i].Version < m.migrations[j].Version })
+	for i, migration := range m.migrations {
+		if migration.Version <= 0 || migration.Up == nil {
+			return nil, fmt.Errorf("es: migration %d requires a positive version and an up step", migration.Version)
+		}
+		if i > 0 && m.migrations[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("es: duplicate migration version %d", migration.Version)
+		}
+	}
+	return m, nil
+}
+
+type migrationRecord struct {
+	Target      string    `json:"target"`
+	Kind        string    `json:"kind"` //applied、lock
+	Version     int64     `json:"version,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Time        time.Time `json:"time"`
+	Expires     time.Time `json:"expires"`
+	TookMs      int64     `json:"took_ms,omitempty"`
+}
+
+func (m *Migrator) versionID(version int64) string {
+	return fmt.Sprintf("%s:%d", m.target, version)
+}
+
+func (m *Migrator) lockID() string {
+	return m.target + ":lock"
+}
+
+// Plan 返回全部版本及其执行状态，可以作为dry run输出，不会加锁也不会修改任何数据
+func (m *Migrator) Plan(ctx context.Context) ([]*MigrationStep, error) {
+	steps := make([]*MigrationStep, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		steps = append(steps, &MigrationStep{Version: migration.Version, Description: migration.Description})
+	}
+	if len(steps) == 0 {
+		return steps, nil
+	}
+	exists, err := m.client.IndexExists(ctx, m.index, false)
+	if err != nil || !exists {
+		return steps, err
+	}
+	items := make([]Mget, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		items = append(items, Mget{Index: m.index, ID: m.versionID(migration.Version)})
+	}
+	//mget是实时的，不需要refresh也能读到最新写入的版本
+	docs, err := MultiGetAs[migrationRecord](ctx, m.client, items)
+	if err != nil {
+		return nil, err
+	}
+	for i, doc := range docs {
+		if doc.Error != nil {
+			return nil, fmt.Errorf("es: load migration %d: %s", steps[i].Version, doc.Error.Reason)
+		}
+		steps[i].Applied = doc.Found
+		steps[i].AppliedAt = doc.Source.Time
+	}
+	return steps, nil
+}
+
+// Up 获取锁后按版本顺序执行未执行的Migration，返回本次执行的版本。
+// 锁被其他实例持有时返回ErrMigrationLocked
+func (m *Migrator) Up(ctx context.Context) ([]int64, error) {
+	if err := m.client.CreateIndex(ctx, m.index, migrationIndexBody, false); err != nil {
+		return nil, err
+	}
+	lock, err := m.acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer m.releaseLock(lock)
+
+	//获取锁之后再读取状态，避免其他实例刚执行完的版本被重复执行
+	steps, err := m.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]int64, 0)
+	for i, step := range steps {
+		if step.Applied {
+			continue
+		}
+		migration := m.migrations[i]
+		m.client.logger().Info("es migration start", Any("target", m.target), Any("version", migration.Version), Any("description", migration.Description))
+		start := time.Now()
+		if err = m.runLocked(ctx, lock, migration); err != nil {
+			return applied, fmt.Errorf("es: migration %d on %s: %w", migration.Version, m.target, err)
+		}
+		record := &migrationRecord{
+			Target:      m.target,
+			Kind:        "applied",
+			Version:     migration.Version,
+			Description: migration.Description,
+			Owner:       m.owner,
+			Time:        time.Now(),
+			TookMs:      time.Since(start).Milliseconds(),
+		}
+		if _, err = m.client.Client.Index().Index(m.index).Id(m.versionID(migration.Version)).BodyJson(record).Refresh(DefaultRefresh).Do(ctx); err != nil {
+			return applied, fmt.Errorf("es: record migration %d on %s: %w", migration.Version, m.target, err)
+		}
+		applied = append(applied, migration.Version)
+		if err = m.renewLock(ctx, lock); err != nil {
+			return applied, err
+		}
+	}
+	return applied, nil
+}
+
+// runLocked 执行Up期间定时续期锁，续期失败说明锁可能已被其他实例抢占，取消Up并返回续期的错误
+func (m *Migrator) runLocked(ctx context.Context, lock *migrationLock, migration Migration) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var renewErr error
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(m.lockTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if renewErr = m.renewLock(runCtx, lock); renewErr != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := migration.Up(runCtx, m.client, m.target)
+	close(stop)
+	<-done
+	if renewErr != nil {
+		return renewErr
+	}
+	return err
+}
+
+// migrationLock 通过seq_no和primary_term保证只修改自己持有的锁
+type migrationLock struct {
+	seqNo       int64
+	primaryTerm int64
+}
+
+func (m *Migrator) lockRecord() *migrationRecord {
+	now := time.Now()
+	return &migrationRecord{Target: m.target, Kind: "lock", Owner: m.owner, Time: now, Expires: now.Add(m.lockTTL)}
+}
+
+func (m *Migrator) acquireLock(ctx context.Context) (*migrationLock, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		res, err := m.client.Client.Index().Index(m.index).Id(m.lockID()).OpType("create").
+			BodyJson(m.lockRecord()).Refresh(DefaultRefresh).Do(ctx)
+		if err == nil {
+			return &migrationLock{seqNo: res.SeqNo, primaryTerm: res.PrimaryTerm}, nil
+		}
+		if !elastic.IsConflict(err) {
+			return nil, err
+		}
+		current, err := m.client.Client.Get().Index(m.index).Id(m.lockID()).Do(ctx)
+		if elastic.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		holder := &migrationRecord{}
+		if err = json.Unmarshal(current.Source, holder); err != nil {
+			return nil, err
+		}
+		if time.Now().Before(holder.Expires) {
+			return nil, fmt.Errorf("%w: %s until %s", ErrMigrationLocked, holder.Owner, holder.Expires.Format(time.RFC3339))
+		}
+		//锁已过期，只删除读到的这个版本，其他实例同时抢占时只有一个能成功
+		m.client.logger().Warn("es migration lock expired", Any("target", m.target), Any("owner", holder.Owner))
+		_, err = m.client.Client.Delete().Index(m.index).Id(m.lockID()).
+			IfSeqNo(*current.SeqNo).IfPrimaryTerm(*current.PrimaryTerm).Do(ctx)
+		if err != nil && !elastic.IsConflict(err) && !elastic.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, ErrMigrationLocked
+}
+
+func (m *Migrator) renewLock(ctx context.Context, lock *migrationLock) error {
+	res, err := m.client.Client.Index().Index(m.index).Id(m.lockID()).BodyJson(m.lockRecord()).
+		IfSeqNo(lock.seqNo).IfPrimaryTerm(lock.primaryTerm).Refresh(DefaultRefresh).Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return fmt.Errorf("%w: lock on %s was taken over", ErrMigrationLocked, m.target)
+		}
+		return err
+	}
+	lock.seqNo, lock.primaryTerm = res.SeqNo, res.PrimaryTerm
+	return nil
+}
+
+// releaseLock 不使用调用方的ctx，保证ctx取消后也能释放锁
+func (m *Migrator) releaseLock(lock *migrationLock) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := m.client.Client.Delete().Index(m.index).Id(m.lockID()).
+		IfSeqNo(lock.seqNo).IfPrimaryTerm(lock.primaryTerm).Refresh(DefaultRefresh).Do(ctx)
+	if err != nil {
+		m.client.logger().Warn("es migration release lock error", Any("target", m.target), Err(err))
+	}
+}
@@ -0,0 +1,344 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeMigrationDoc struct {
+	source json.RawMessage
+	seqNo  int64
+}
+
+// fakeMigrationServer 在内存中维护元数据索引的文档，支持create、if_seq_no条件写入和删除以及_mget
+type fakeMigrationServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	exists   bool
+	seqNo    int64
+	docs     map[string]*fakeMigrationDoc
+	settings []string
+}
+
+func newFakeMigrationServer(t *testing.T) *fakeMigrationServer {
+	s := &fakeMigrationServer{docs: make(map[string]*fakeMigrationDoc)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		conflict := func() {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":{"type":"version_conflict_engine_exception","reason":"conflict"},"status":409}`))
+		}
+		switch {
+		case r.URL.Path == "/":
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/"+DefaultMigrationIndex:
+			if r.Method == http.MethodPut {
+				s.exists = true
+				w.Write([]byte(`{"acknowledged":true,"index":".es_migrations"}`))
+			} else if !s.exists {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.URL.Path == "/_mget":
+			//ES 7移除了_primary等preference，未知的_开头的值返回400
+			if pref := r.URL.Query().Get("preference"); strings.HasPrefix(pref, "_") && !validTestPreference(pref) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error":{"type":"illegal_argument_exception","reason":"no Preference for [%s]"},"status":400}`, pref)
+				return
+			}
+			req := struct {
+				Docs []struct {
+					ID string `json:"_id"`
+				} `json:"docs"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&req)
+			docs := make([]string, 0, len(req.Docs))
+			for _, item := range req.Docs {
+				if doc, ok := s.docs[item.ID]; ok {
+					docs = append(docs, fmt.Sprintf(`{"_index":%q,"_id":%q,"found":true,"_source":%s}`, DefaultMigrationIndex, item.ID, doc.source))
+				} else {
+					docs = append(docs, fmt.Sprintf(`{"_index":%q,"_id":%q,"found":false}`, DefaultMigrationIndex, item.ID))
+				}
+			}
+			w.Write([]byte(`{"docs":[` + strings.Join(docs, ",") + `]}`))
+		case strings.HasPrefix(r.URL.Path, "/"+DefaultMigrationIndex+"/_doc/"):
+			id := strings.TrimPrefix(r.URL.Path, "/"+DefaultMigrationIndex+"/_doc/")
+			doc, found := s.docs[id]
+			q := r.URL.Query()
+			if ifSeqNo := q.Get("if_seq_no"); ifSeqNo != "" && (!found || ifSeqNo != fmt.Sprint(doc.seqNo)) {
+				conflict()
+				return
+			}
+			switch r.Method {
+			case http.MethodGet:
+				if !found {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"found":false}`))
+					return
+				}
+				fmt.Fprintf(w, `{"_index":%q,"_id":%q,"found":true,"_seq_no":%d,"_primary_term":1,"_source":%s}`, DefaultMigrationIndex, id, doc.seqNo, doc.source)
+			case http.MethodDelete:
+				if !found {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"result":"not_found"}`))
+					return
+				}
+				delete(s.docs, id)
+				w.Write([]byte(`{"result":"deleted"}`))
+			default:
+				if found && q.Get("op_type") == "create" {
+					conflict()
+					return
+				}
+				body := json.RawMessage{}
+				json.NewDecoder(r.Body).Decode(&body)
+				s.seqNo++
+				s.docs[id] = &fakeMigrationDoc{source: body, seqNo: s.seqNo}
+				fmt.Fprintf(w, `{"_index":%q,"_id":%q,"_seq_no":%d,"_primary_term":1,"result":"created"}`, DefaultMigrationIndex, id, s.seqNo)
+			}
+		case strings.HasSuffix(r.URL.Path, "/_settings") && r.Method == http.MethodPut:
+			body := json.RawMessage{}
+			json.NewDecoder(r.Body).Decode(&body)
+			s.settings = append(s.settings, string(body))
+			w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+func validTestPreference(pref string) bool {
+	for _, valid := range []string{"_local", "_only_local", "_only_nodes:", "_prefer_nodes:", "_shards:"} {
+		if pref == valid || strings.HasSuffix(valid, ":") && strings.HasPrefix(pref, valid) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeMigrationServer) putLock(target, owner string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := json.Marshal(&migrationRecord{Target: target, Kind: "lock", Owner: owner, Time: time.Now(), Expires: expires})
+	s.seqNo++
+	s.exists = true
+	s.docs[target+":lock"] = &fakeMigrationDoc{source: body, seqNo: s.seqNo}
+}
+
+func (s *fakeMigrationServer) has(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.docs[id]
+	return ok
+}
+
+func TestMigratorUp(t *testing.T) {
+	srv := newFakeMigrationServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	var calls []int64
+	step := func(version int64) Migration {
+		return Migration{Version: version, Description: fmt.Sprintf("step %d", version), Up: func(ctx context.Context, c *Client, target string) error {
+			if target != "orders" {
+				return fmt.Errorf("unexpected target %s", target)
+			}
+			calls = append(calls, version)
+			return nil
+		}}
+	}
+	migrations := []Migration{
+		step(3),
+		step(1),
+		UpdateSettingsMigration(2, "refresh interval", map[string]interface{}{"index": map[string]interface{}{"refresh_interval": "5s"}}),
+	}
+	m, err := NewMigrator(c, "orders", migrations, WithMigrationOwner("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan, err := m.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 3 || plan[0].Version != 1 || plan[1].Version != 2 || plan[2].Version != 3 || plan[0].Applied {
+		t.Fatalf("unexpected dry run plan %v", plan)
+	}
+	if len(calls) != 0 || srv.exists {
+		t.Fatal("dry run must not apply migrations or create the metadata index")
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(applied) != "[1 2 3]" || fmt.Sprint(calls) != "[1 3]" {
+		t.Fatalf("unexpected applied %v calls %v", applied, calls)
+	}
+	if len(srv.settings) != 1 || !strings.Contains(srv.settings[0], "refresh_interval") {
+		t.Fatalf("unexpected settings update %v", srv.settings)
+	}
+	if srv.has("orders:lock") || !srv.has("orders:2") {
+		t.Fatal("expected versions recorded and lock released")
+	}
+
+	plan, err = m.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range plan {
+		if !s.Applied || s.AppliedAt.IsZero() {
+			t.Fatalf("expected %s to be applied", s)
+		}
+	}
+	if applied, err = m.Up(ctx); err != nil || len(applied) != 0 || len(calls) != 2 {
+		t.Fatalf("expected nothing to apply, got %v %v", applied, err)
+	}
+}
+
+func TestMigratorResumesAfterFailure(t *testing.T) {
+	srv := newFakeMigrationServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	fail := errors.New("boom")
+	var calls []int64
+	migrations := []Migration{
+		{Version: 1, Up: func(ctx context.Context, c *Client, target string) error { calls = append(calls, 1); return nil }},
+		{Version: 2, Up: func(ctx context.Context, c *Client, target string) error {
+			calls = append(calls, 2)
+			if len(calls) == 2 {
+				return fail
+			}
+			return nil
+		}},
+	}
+	m, err := NewMigrator(c, "orders", migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Up(ctx)
+	if !errors.Is(err, fail) || fmt.Sprint(applied) != "[1]" {
+		t.Fatalf("expected failure after version 1, got %v %v", applied, err)
+	}
+	if srv.has("orders:lock") {
+		t.Fatal("expected lock released after failure")
+	}
+	applied, err = m.Up(ctx)
+	if err != nil || fmt.Sprint(applied) != "[2]" || fmt.Sprint(calls) != "[1 2 2]" {
+		t.Fatalf("expected resume at version 2, got %v %v calls %v", applied, err, calls)
+	}
+}
+
+func TestMigratorLock(t *testing.T) {
+	srv := newFakeMigrationServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	called := 0
+	migrations := []Migration{{Version: 1, Up: func(ctx context.Context, c *Client, target string) error { called++; return nil }}}
+	m, err := NewMigrator(c, "orders", migrations, WithMigrationOwner("me"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.putLock("orders", "other", time.Now().Add(time.Minute))
+	if _, err = m.Up(ctx); !errors.Is(err, ErrMigrationLocked) || called != 0 {
+		t.Fatalf("expected locked error, got %v", err)
+	}
+	if !srv.has("orders:lock") {
+		t.Fatal("lock held by another instance must not be released")
+	}
+
+	srv.putLock("orders", "crashed", time.Now().Add(-time.Minute))
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 1 || called != 1 {
+		t.Fatalf("expected expired lock to be taken over, got %v %v", applied, err)
+	}
+	if srv.has("orders:lock") {
+		t.Fatal("expected lock released")
+	}
+}
+
+func TestNewMigratorValidation(t *testing.T) {
+	up := func(ctx context.Context, c *Client, target string) error { return nil }
+	if _, err := NewMigrator(nil, "orders", []Migration{{Version: 1, Up: up}, {Version: 1, Up: up}}); err == nil {
+		t.Fatal("expected duplicate version error")
+	}
+	if _, err := NewMigrator(nil, "orders", []Migration{{Version: 0, Up: up}}); err == nil {
+		t.Fatal("expected invalid version error")
+	}
+	if _, err := NewMigrator(nil, "orders", []Migration{{Version: 1}}); err == nil {
+		t.Fatal("expected missing up step error")
+	}
+	if _, err := NewMigrator(nil, "orders", nil, WithMigrationLockTTL(0)); err == nil {
+		t.Fatal("expected invalid lock ttl error")
+	}
+}
+
+func TestMigratorRenewsLockDuringStep(t *testing.T) {
+	srv := newFakeMigrationServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	other, err := NewMigrator(c, "orders", nil, WithMigrationOwner("other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var otherErr error
+	slow := Migration{Version: 1, Up: func(ctx context.Context, c *Client, target string) error {
+		//超过锁的有效期，续期后其他实例仍然拿不到锁
+		time.Sleep(200 * time.Millisecond)
+		_, otherErr = other.Up(ctx)
+		return ctx.Err()
+	}}
+	m, err := NewMigrator(c, "orders", []Migration{slow}, WithMigrationOwner("me"), WithMigrationLockTTL(60*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 1 {
+		t.Fatalf("expected slow migration to be applied, got %v %v", applied, err)
+	}
+	if !errors.Is(otherErr, ErrMigrationLocked) {
+		t.Fatalf("expected lock to be renewed while the step runs, got %v", otherErr)
+	}
+}
+
+func TestMigratorCancelsStepWhenLockIsLost(t *testing.T) {
+	srv := newFakeMigrationServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	lost := Migration{Version: 1, Up: func(ctx context.Context, c *Client, target string) error {
+		srv.putLock("orders", "other", time.Now().Add(time.Minute))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+			return errors.New("step was not cancelled")
+		}
+	}}
+	m, err := NewMigrator(c, "orders", []Migration{lost}, WithMigrationLockTTL(60*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Up(context.Background())
+	if !errors.Is(err, ErrMigrationLocked) || len(applied) != 0 {
+		t.Fatalf("expected lost lock error, got %v %v", applied, err)
+	}
+	if srv.has("orders:1") {
+		t.Fatal("cancelled step must not be recorded")
+	}
+	if !srv.has("orders:lock") {
+		t.Fatal("lock taken over by another instance must not be released")
+	}
+}
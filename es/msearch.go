@@ -0,0 +1,73 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"strings"
+)
+
+// MultiSearchRequest 一次_msearch中的一个子查询，参数与Query相同
+type MultiSearchRequest struct {
+	Index   string
+	Routes  []string
+	Query   elastic.Query
+	From    int
+	Size    int
+	Options []QueryOption
+}
+
+// MultiSearchResult 子查询结果，Err为该子查询自身的错误
+type MultiSearchResult struct {
+	Result *elastic.SearchResult
+	Err    error
+}
+
+// MultiSearch 通过一次_msearch请求执行多个独立的查询，结果与requests一一对应。
+// 返回的error只表示整个请求失败，子查询失败时记录在对应结果的Err中
+func (c *Client) MultiSearch(ctx context.Context, requests []*MultiSearchRequest) ([]*MultiSearchResult, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	queryOpts := make([]*queryOption, len(requests))
+	dsls := make([][]byte, len(requests))
+	msearchService := c.Client.MultiSearch()
+	for i, req := range requests {
+		queryOpt := newQueryOption(req.Options)
+		searchSource := queryOpt.page(queryOpt.searchSource(req.Query), req.From, req.Size)
+		searchRequest := elastic.NewSearchRequest().Index(req.Index).SearchSource(searchSource).
+			IgnoreUnavailable(true).Preference(queryOpt.preference())
+		if len(req.Routes) > 0 {
+			searchRequest.Routings(req.Routes...)
+		}
+		msearchService.Add(searchRequest)
+
+		//获取查询语句
+		src, _ := searchSource.Source()
+		data, _ := json.Marshal(src)
+		if c.DebugMode || c.QueryLogEnable || queryOpt.EnableDSL {
+			c.logger().Info("es multi search", Any("index", req.Index), Any("dsl", string(data)), Any("routing", strings.Join(req.Routes, ",")))
+		}
+		queryOpts[i] = queryOpt
+		dsls[i] = data
+	}
+
+	res, err := msearchService.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Responses) != len(requests) {
+		return nil, fmt.Errorf("es: msearch expected %d responses, got %d", len(requests), len(res.Responses))
+	}
+	results := make([]*MultiSearchResult, len(requests))
+	for i, sub := range res.Responses {
+		if sub != nil && sub.Error != nil {
+			results[i] = &MultiSearchResult{Err: &elastic.Error{Status: sub.Status, Details: sub.Error}}
+			continue
+		}
+		c.recordSlowQuery(queryOpts[i], requests[i].Index, requests[i].Routes, dsls[i], sub)
+		results[i] = &MultiSearchResult{Result: sub}
+	}
+	return results, nil
+}
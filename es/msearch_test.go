@@ -0,0 +1,59 @@
+package es
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMultiSearch(t *testing.T) {
+	var headers []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_msearch" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			if i%2 == 0 {
+				header := make(map[string]interface{})
+				json.Unmarshal(scanner.Bytes(), &header)
+				headers = append(headers, header)
+			}
+		}
+		w.Write([]byte(`{"took":30,"responses":[
+			{"took":30,"status":200,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_index":"a","_id":"1","_source":{}}]}},
+			{"status":400,"error":{"type":"parsing_exception","reason":"bad query"}}]}`))
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	sink := NewRingSlowQuerySink(10)
+	c.SlowQuerySink = sink
+
+	res, err := c.MultiSearch(context.Background(), []*MultiSearchRequest{
+		{Index: "a", Routes: []string{"r1", "r2"}, Query: elastic.NewMatchAllQuery(), Size: 10, Options: []QueryOption{WithSlowQueryMillisecond(10)}},
+		{Index: "b", Query: elastic.NewTermQuery("f", "v"), Options: []QueryOption{WithPreference("_only_local")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 || headers[0]["routing"] != "r1,r2" || headers[0]["preference"] != DefaultPreference || headers[1]["preference"] != "_only_local" {
+		t.Fatalf("unexpected msearch headers %v", headers)
+	}
+	if res[0].Err != nil || res[0].Result.Hits.Hits[0].Id != "1" {
+		t.Fatalf("unexpected first result %+v", res[0])
+	}
+	if !elastic.IsStatusCode(res[1].Err, http.StatusBadRequest) || !strings.Contains(res[1].Err.Error(), "bad query") {
+		t.Fatalf("expected per request error, got %v", res[1].Err)
+	}
+	records := sink.Records()
+	if len(records) != 1 || records[0].Index != "a" || records[0].Routing != "r1,r2" || !strings.Contains(records[0].DSL, "match_all") {
+		t.Fatalf("unexpected slow query records %+v", records)
+	}
+}
@@ -0,0 +1,180 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"strings"
+)
+
+// DefaultKeepAlive PIT在两次翻页之间的保留时间
+const DefaultKeepAlive = "1m"
+
+var ErrInvalidCursor = errors.New("es: invalid cursor")
+
+// WithKeepAlive 设置PIT或scroll上下文的保留时间，例如"5m"，需要覆盖两次翻页之间的间隔
+func WithKeepAlive(keepAlive string) QueryOption {
+	return func(opt *queryOption) {
+		opt.KeepAlive = keepAlive
+	}
+}
+
+func (opt *queryOption) keepAlive() string {
+	if len(opt.KeepAlive) > 0 {
+		return opt.KeepAlive
+	}
+	return DefaultKeepAlive
+}
+
+// Cursor search_after翻页状态，通过Encode序列化后可以直接交给前端。
+// 游标没有签名，只包含PIT id和sort值，保留时间等参数由服务端每次通过options指定
+type Cursor struct {
+	PitID       string        `json:"pit"`
+	SearchAfter []interface{} `json:"after,omitempty"`
+}
+
+// Encode 序列化为URL安全的base64字符串
+func (cur *Cursor) Encode() (string, error) {
+	data, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor 解析Encode生成的游标，sort值使用json.Number保留long类型的精度，
+// QueryAfter解析响应时同样使用json.Number，超过2^53的sort值也不会丢失精度
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	cur := &Cursor{}
+	if err = decoder.Decode(cur); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	if len(cur.PitID) == 0 {
+		return nil, fmt.Errorf("%w: missing pit id", ErrInvalidCursor)
+	}
+	return cur, nil
+}
+
+// CursorPage 一页查询结果，Cursor为空表示已经没有下一页，PIT已关闭
+type CursorPage struct {
+	Result *elastic.SearchResult
+	Cursor string
+}
+
+// QueryAfter 基于PIT+search_after的分页查询，不受index.max_result_window限制，翻页期间结果不受写入影响。
+// cursor为空时在indexName上打开PIT并返回第一页，之后传入上一页返回的Cursor，query和options需要与第一页保持一致。
+// routes和preference只在打开PIT时生效；排序会追加_shard_doc作为唯一的tiebreaker。
+// 最后一页会自动关闭PIT，中途放弃翻页时可以调用ClosePointInTime提前释放
+func (c *Client) QueryAfter(ctx context.Context, indexName string, routes []string, query elastic.Query, cursor string, size int, options ...QueryOption) (*CursorPage, error) {
+	queryOpt := newQueryOption(options)
+	var (
+		cur    *Cursor
+		err    error
+		opened bool
+	)
+	if len(cursor) > 0 {
+		if cur, err = DecodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	} else {
+		openService := c.Client.OpenPointInTime(indexName).KeepAlive(queryOpt.keepAlive()).Preference(queryOpt.preference())
+		if len(routes) > 0 {
+			openService.Routing(strings.Join(routes, ","))
+		}
+		pit, err := openService.Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cur = &Cursor{PitID: pit.Id}
+		opened = true
+	}
+
+	searchSource := queryOpt.searchSource(query).Size(size).
+		Sort("_shard_doc", true).
+		PointInTime(elastic.NewPointInTimeWithKeepAlive(cur.PitID, queryOpt.keepAlive()))
+	if len(cur.SearchAfter) > 0 {
+		searchSource.SearchAfter(cur.SearchAfter...)
+	}
+	//获取查询语句
+	src, err := searchSource.Source()
+	if err != nil {
+		if opened {
+			c.closePointInTime(ctx, cur.PitID)
+		}
+		return nil, err
+	}
+	data, _ := json.Marshal(src)
+	res, err := c.searchUseNumber(ctx, data)
+	if c.DebugMode || c.QueryLogEnable || queryOpt.EnableDSL {
+		c.logger().Info("es query after", Any("index", indexName), Any("dsl", string(data)), Any("routing", strings.Join(routes, ",")))
+	}
+	c.recordSlowQuery(queryOpt, indexName, routes, data, res)
+	if err != nil {
+		//本次打开的PIT没有交给调用方，需要释放
+		if opened {
+			c.closePointInTime(ctx, cur.PitID)
+		}
+		return nil, err
+	}
+
+	page := &CursorPage{Result: res}
+	if res.Hits == nil || len(res.Hits.Hits) == 0 || len(res.Hits.Hits) < size {
+		c.closePointInTime(ctx, cur.PitID)
+		return page, nil
+	}
+	next := &Cursor{PitID: cur.PitID, SearchAfter: res.Hits.Hits[len(res.Hits.Hits)-1].Sort}
+	//每次查询ES都可能返回新的PIT id
+	if len(res.PitId) > 0 {
+		next.PitID = res.PitId
+	}
+	if page.Cursor, err = next.Encode(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// searchUseNumber 执行PIT查询，SearchService会把sort值解析为float64，这里改用json.Number解析响应
+func (c *Client) searchUseNumber(ctx context.Context, body json.RawMessage) (*elastic.SearchResult, error) {
+	resp, err := c.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: http.MethodPost,
+		Path:   "/_search",
+		Body:   body,
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := &elastic.SearchResult{Header: resp.Header}
+	decoder := json.NewDecoder(bytes.NewReader(resp.Body))
+	decoder.UseNumber()
+	if err = decoder.Decode(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ClosePointInTime 释放游标对应的PIT
+func (c *Client) ClosePointInTime(ctx context.Context, cursor string) error {
+	cur, err := DecodeCursor(cursor)
+	if err != nil {
+		return err
+	}
+	_, err = c.Client.ClosePointInTime(cur.PitID).Do(ctx)
+	return err
+}
+
+func (c *Client) closePointInTime(ctx context.Context, pitID string) {
+	if _, err := c.Client.ClosePointInTime(pitID).Do(ctx); err != nil {
+		c.logger().Warn("close point in time error", Any("client", c.Name), Err(err))
+	}
+}
@@ -0,0 +1,103 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestQueryAfter(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		closed []string
+		afters []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/idx/_pit":
+			if r.URL.Query().Get("routing") != "r1" {
+				t.Errorf("expected routing on open pit, got %q", r.URL.RawQuery)
+			}
+			w.Write([]byte(`{"id":"pit-1"}`))
+		case r.URL.Path == "/_search":
+			req := struct {
+				Pit struct {
+					ID        string `json:"id"`
+					KeepAlive string `json:"keep_alive"`
+				} `json:"pit"`
+				Sort        []interface{}   `json:"sort"`
+				SearchAfter json.RawMessage `json:"search_after"`
+			}{}
+			json.Unmarshal(body, &req)
+			if len(req.Sort) != 2 {
+				t.Errorf("expected _shard_doc tiebreaker, got %s", body)
+			}
+			afters = append(afters, string(req.SearchAfter))
+			if req.Pit.KeepAlive != "5m" {
+				t.Errorf("expected keep_alive from options, got %q", req.Pit.KeepAlive)
+			}
+			if req.SearchAfter == nil {
+				w.Write([]byte(`{"pit_id":"pit-2","hits":{"total":{"value":3,"relation":"eq"},"hits":[
+					{"_index":"idx","_id":"1","_source":{"title":"a"},"sort":[1,10]},
+					{"_index":"idx","_id":"2","_source":{"title":"b"},"sort":[9007199254740993,11]}]}}`))
+				return
+			}
+			w.Write([]byte(`{"pit_id":"pit-2","hits":{"total":{"value":3,"relation":"eq"},"hits":[
+				{"_index":"idx","_id":"3","_source":{"title":"c"},"sort":[1700000000000124,12]}]}}`))
+		case r.URL.Path == "/_pit" && r.Method == http.MethodDelete:
+			closed = append(closed, string(body))
+			w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	page, err := QueryAfterAs[testDoc](ctx, c, "idx", []string{"r1"}, nil, "", 2, WithOrders([]map[string]bool{{"ts": true}}), WithKeepAlive("5m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Hits) != 2 || page.Cursor == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	cur, err := DecodeCursor(page.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.PitID != "pit-2" || fmt.Sprint(cur.SearchAfter) != "[9007199254740993 11]" {
+		t.Fatalf("unexpected cursor %+v", cur)
+	}
+
+	page, err = QueryAfterAs[testDoc](ctx, c, "idx", []string{"r1"}, nil, page.Cursor, 2, WithOrders([]map[string]bool{{"ts": true}}), WithKeepAlive("5m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Hits) != 1 || page.Hits[0].Source.Title != "c" || page.Cursor != "" {
+		t.Fatalf("unexpected last page %+v", page)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(afters) != 2 || afters[1] != "[9007199254740993,11]" {
+		t.Fatalf("search_after lost precision: %v", afters)
+	}
+	if len(closed) != 1 || !strings.Contains(closed[0], "pit-2") {
+		t.Fatalf("expected pit to be closed after last page, got %v", closed)
+	}
+
+	if _, err = c.QueryAfter(ctx, "idx", nil, nil, "not a cursor", 2); !errors.Is(err, ErrInvalidCursor) {
+		t.Fatalf("expected invalid cursor error, got %v", err)
+	}
+}
@@ -3,8 +3,8 @@ package es
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/olivere/elastic/v7"
-	"io"
 	"strings"
 )
 
@@ -24,11 +24,22 @@ type queryOption struct {
 	SlowQueryMillisecond int64
 	Preference           string
 	FetchSource          *bool
+	Version              bool
+	SeqNoPrimaryTerm     bool
+	KeepAlive            string //PIT、scroll上下文的保留时间
+	SliceProgress        func(progress SliceProgress)
+	BatchSize            int //MultiGet等批量接口每次请求的最大条数
+	Aggregations         map[string]elastic.Aggregation
+	AggregationOnly      bool //只返回聚合结果，size固定为0
+	TerminateAfter       int
 }
 type QueryOption func(queryOption *queryOption)
 
 const DefaultPreference = "_local"
 
+// DefaultBatchSize 批量查询每次请求的默认条数
+const DefaultBatchSize = 1000
+
 func WithOrders(orders []map[string]bool) QueryOption {
 	return func(opt *queryOption) {
 		opt.Orders = orders
@@ -63,6 +74,7 @@ func WithExcludeFields(excludeFields []string) QueryOption {
 	}
 }
 
+// WithSlowQueryMillisecond 覆盖客户端的全局慢查询阈值，小于0表示本次查询不记录慢查询
 func WithSlowQueryMillisecond(slowQueryLogMillisecond int64) QueryOption {
 	return func(opt *queryOption) {
 		opt.SlowQueryMillisecond = slowQueryLogMillisecond
@@ -81,136 +93,172 @@ func WithFetchSource(fetchSource bool) QueryOption {
 	}
 }
 
-func (c *Client) Get(ctx context.Context, indexName, id, routing string) (*elastic.GetResult, error) {
-	getService := c.Client.Get().Index(indexName).Id(id).Preference(DefaultPreference)
-	if len(routing) > 0 {
-		getService.Routing(routing)
+// WithVersion 返回文档的_version
+func WithVersion(version bool) QueryOption {
+	return func(opt *queryOption) {
+		opt.Version = version
 	}
-	return getService.Do(ctx)
 }
 
-func (c *Client) Query(ctx context.Context, indexName string, routes []string, query elastic.Query, from, size int, options ...QueryOption) (*elastic.SearchResult, error) {
+// WithSeqNoPrimaryTerm 返回文档的_seq_no和_primary_term，用于乐观锁
+func WithSeqNoPrimaryTerm(seqNoPrimaryTerm bool) QueryOption {
+	return func(opt *queryOption) {
+		opt.SeqNoPrimaryTerm = seqNoPrimaryTerm
+	}
+}
+
+// WithBatchSize 设置批量查询每次请求的最大条数，超过后自动拆分为多次请求
+func WithBatchSize(batchSize int) QueryOption {
+	return func(opt *queryOption) {
+		opt.BatchSize = batchSize
+	}
+}
+
+func newQueryOption(options []QueryOption) *queryOption {
 	queryOpt := &queryOption{}
 	for _, f := range options {
 		if f != nil {
 			f(queryOpt)
 		}
 	}
+	return queryOpt
+}
+
+func (opt *queryOption) preference() string {
+	if len(opt.Preference) > 0 {
+		return opt.Preference
+	}
+	return DefaultPreference
+}
+
+func (opt *queryOption) batchSize() int {
+	if opt.BatchSize > 0 {
+		return opt.BatchSize
+	}
+	return DefaultBatchSize
+}
+
+func (opt *queryOption) fetchSourceContext() *elastic.FetchSourceContext {
 	//设置Source
 	fetchSource := true
-	if queryOpt.FetchSource != nil && *queryOpt.FetchSource == false {
+	if opt.FetchSource != nil && *opt.FetchSource == false {
 		fetchSource = false
 	}
 	fetchSourceContext := elastic.NewFetchSourceContext(fetchSource)
-	if len(queryOpt.IncludeFields) > 0 {
-		fetchSourceContext.Include(queryOpt.IncludeFields...)
+	if len(opt.IncludeFields) > 0 {
+		fetchSourceContext.Include(opt.IncludeFields...)
 	}
-	if len(queryOpt.ExcludeFields) > 0 {
-		fetchSourceContext.Exclude(queryOpt.ExcludeFields...)
+	if len(opt.ExcludeFields) > 0 {
+		fetchSourceContext.Exclude(opt.ExcludeFields...)
 	}
-	//构造查询条件
-	searchSource := elastic.NewSearchSource()
-	searchSource = searchSource.FetchSourceContext(fetchSourceContext).Query(query).From(from).Size(size)
-	if len(queryOpt.Orders) > 0 {
-		for _, orderM := range queryOpt.Orders {
+	return fetchSourceContext
+}
+
+// searchSource 根据查询选项构造SearchSource，from、size由调用方设置
+func (opt *queryOption) searchSource(query elastic.Query) *elastic.SearchSource {
+	searchSource := elastic.NewSearchSource().FetchSourceContext(opt.fetchSourceContext()).Query(query)
+	if len(opt.Orders) > 0 {
+		for _, orderM := range opt.Orders {
 			for field, order := range orderM {
 				searchSource.Sort(field, order)
 			}
 		}
 	}
-	if queryOpt.Highlight != nil {
-		searchSource.Highlight(queryOpt.Highlight)
-	}
-	searchSource.Profile(queryOpt.Profile)
-
-	searchService := c.Client.Search(indexName).SearchSource(searchSource).IgnoreUnavailable(true).Preference(DefaultPreference)
-	if len(routes) > 0 {
-		searchService.Routing(routes...)
+	if opt.Highlight != nil {
+		searchSource.Highlight(opt.Highlight)
 	}
-	if len(queryOpt.Preference) > 0 {
-		searchService.Preference(queryOpt.Preference)
-	} else {
-		searchService.Preference(DefaultPreference)
+	if opt.Version {
+		searchSource.Version(true)
 	}
-
-	res, err := searchService.Do(ctx)
-	//获取查询语句
-	src, _ := searchSource.Source()
-	data, _ := json.Marshal(src)
-	rs := strings.Join(routes, ",")
-	if c.DebugMode || c.QueryLogEnable || queryOpt.EnableDSL {
-		EStdLogger.Print("DSL : ", string(data), "routing: ", rs)
+	if opt.SeqNoPrimaryTerm {
+		searchSource.SeqNoAndPrimaryTerm(true)
 	}
-	if queryOpt.SlowQueryMillisecond > 0 && res != nil && res.TookInMillis >= queryOpt.SlowQueryMillisecond {
-		EStdLogger.Print("slow query DSL : ", string(data), "routing: ", rs)
+	for name, aggregation := range opt.Aggregations {
+		searchSource.Aggregation(name, aggregation)
 	}
-	return res, err
+	searchSource.Profile(opt.Profile)
+	return searchSource
 }
 
-func (c *Client) ScrollQuery(ctx context.Context, index []string, typeStr string, query elastic.Query, size int, routes []string, callback func(res *elastic.SearchResult, err error), options ...QueryOption) {
-	queryOpt := &queryOption{}
-	for _, f := range options {
-		if f != nil {
-			f(queryOpt)
-		}
+// page 设置from、size，只查询聚合时不返回文档
+func (opt *queryOption) page(searchSource *elastic.SearchSource, from, size int) *elastic.SearchSource {
+	if opt.AggregationOnly {
+		return searchSource.From(0).Size(0)
 	}
-	fetchSource := true
-	if queryOpt.FetchSource != nil && *queryOpt.FetchSource == false {
-		fetchSource = false
+	return searchSource.From(from).Size(size)
+}
+
+func (c *Client) Get(ctx context.Context, indexName, id, routing string) (*elastic.GetResult, error) {
+	getService := c.Client.Get().Index(indexName).Id(id).Preference(DefaultPreference)
+	if len(routing) > 0 {
+		getService.Routing(routing)
 	}
-	fetchSourceContext := elastic.NewFetchSourceContext(fetchSource)
-	searchSource := elastic.NewSearchSource()
-	searchSource = searchSource.FetchSourceContext(fetchSourceContext).Query(query)
+	return getService.Do(ctx)
+}
 
-	if len(queryOpt.Orders) > 0 {
-		for _, orderM := range queryOpt.Orders {
-			for field, order := range orderM {
-				searchSource.Sort(field, order)
+// MultiGet 批量获取文档，结果与items一一对应，通过Found判断文档是否存在，
+// 单个文档的错误(如索引不存在)记录在对应结果的Error中，超过BatchSize时自动拆分请求
+func (c *Client) MultiGet(ctx context.Context, items []Mget, options ...QueryOption) ([]*elastic.GetResult, error) {
+	queryOpt := newQueryOption(options)
+	fetchSourceContext := queryOpt.fetchSourceContext()
+	batchSize := queryOpt.batchSize()
+	results := make([]*elastic.GetResult, 0, len(items))
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		mgetService := c.Client.Mget().Preference(queryOpt.preference())
+		for _, item := range items[start:end] {
+			getItem := elastic.NewMultiGetItem().Index(item.Index).Id(item.ID).FetchSource(fetchSourceContext)
+			if len(item.Routing) > 0 {
+				getItem.Routing(item.Routing)
 			}
+			mgetService.Add(getItem)
+		}
+		res, err := mgetService.Do(ctx)
+		if err != nil {
+			return nil, err
 		}
+		if len(res.Docs) != end-start {
+			return nil, fmt.Errorf("es: mget expected %d docs, got %d", end-start, len(res.Docs))
+		}
+		results = append(results, res.Docs...)
 	}
-	if queryOpt.Highlight != nil {
-		searchSource.Highlight(queryOpt.Highlight)
+	return results, nil
+}
+
+func (c *Client) Query(ctx context.Context, indexName string, routes []string, query elastic.Query, from, size int, options ...QueryOption) (*elastic.SearchResult, error) {
+	queryOpt := newQueryOption(options)
+	//构造查询条件
+	searchSource := queryOpt.page(queryOpt.searchSource(query), from, size)
+
+	searchService := c.Client.Search(indexName).SearchSource(searchSource).IgnoreUnavailable(true)
+	if len(routes) > 0 {
+		searchService.Routing(routes...)
 	}
-	searchSource.Profile(queryOpt.Profile)
+	searchService.Preference(queryOpt.preference())
+
+	res, err := searchService.Do(ctx)
+	//获取查询语句
 	src, _ := searchSource.Source()
 	data, _ := json.Marshal(src)
 	rs := strings.Join(routes, ",")
 	if c.DebugMode || c.QueryLogEnable || queryOpt.EnableDSL {
-		EStdLogger.Print("DSL : ", string(data), "routing: ", rs)
+		c.logger().Info("es query", Any("index", indexName), Any("dsl", string(data)), Any("routing", rs))
 	}
-	scrollService := c.Client.Scroll(index...).SearchSource(searchSource).Size(size).Preference(DefaultPreference)
-	if len(routes) > 0 {
-		scrollService.Routing(routes...)
-	}
-	if len(queryOpt.Preference) > 0 {
-		scrollService.Preference(queryOpt.Preference)
-	} else {
-		scrollService.Preference(DefaultPreference)
-	}
-	//scroll保存在ES集群中的上下文信息会占用大量内存资源，虽然会在一段时间后自动清理，当我们知道scroll结束后,
-	//需要手动调用clear释放资源
-	defer scrollService.Clear(ctx)
-	for {
-		res, err := scrollService.Do(ctx)
-		if err == io.EOF {
-			break
-		}
-		if queryOpt.SlowQueryMillisecond > 0 && res != nil && res.TookInMillis >= queryOpt.SlowQueryMillisecond {
-			EStdLogger.Print("slow query DSL : ", string(data), "routing: ", rs)
-		}
-		if res == nil {
-			EStdLogger.Print("nil results !")
-			break
-		}
-		if res.Hits == nil {
-			EStdLogger.Print("expected results.Hits != nil; got nil")
-		}
+	c.recordSlowQuery(queryOpt, indexName, routes, data, res)
+	return res, err
+}
 
-		if len(res.Hits.Hits) == 0 {
-			break
-		}
-		callback(res, err)
+// ScrollQuery 按页回调scroll查询结果，出错时回调一次错误并停止，结束后清理scroll上下文
+func (c *Client) ScrollQuery(ctx context.Context, index []string, typeStr string, query elastic.Query, size int, routes []string, callback func(res *elastic.SearchResult, err error), options ...QueryOption) {
+	it := c.newScrollIterator(ctx, index, query, size, routes, newQueryOption(options), nil)
+	defer it.Close()
+	for it.nextPage() {
+		callback(it.Page(), nil)
+	}
+	if err := it.Err(); err != nil {
+		callback(nil, err)
 	}
-
 }
@@ -0,0 +1,77 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMultiGet(t *testing.T) {
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_mget" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		atomic.AddInt64(&requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		req := struct {
+			Docs []struct {
+				Index   string `json:"_index"`
+				ID      string `json:"_id"`
+				Routing string `json:"routing"`
+				Source  struct {
+					Includes []string `json:"includes"`
+				} `json:"_source"`
+			} `json:"docs"`
+		}{}
+		json.Unmarshal(body, &req)
+		docs := make([]string, 0, len(req.Docs))
+		for _, doc := range req.Docs {
+			if len(doc.Source.Includes) != 1 || doc.Source.Includes[0] != "title" {
+				t.Errorf("expected source filtering, got %s", body)
+			}
+			switch {
+			case doc.Index == "missing":
+				docs = append(docs, fmt.Sprintf(`{"_index":"missing","_id":"%s","error":{"type":"index_not_found_exception","reason":"no such index"}}`, doc.ID))
+			case strings.HasPrefix(doc.ID, "none"):
+				docs = append(docs, fmt.Sprintf(`{"_index":"%s","_id":"%s","found":false}`, doc.Index, doc.ID))
+			default:
+				docs = append(docs, fmt.Sprintf(`{"_index":"%s","_id":"%s","_routing":"%s","found":true,"_source":{"title":"%s"}}`, doc.Index, doc.ID, doc.Routing, doc.ID))
+			}
+		}
+		fmt.Fprintf(w, `{"docs":[%s]}`, strings.Join(docs, ","))
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	items := []Mget{{Index: "idx", ID: "a", Routing: "r1"}, {Index: "idx", ID: "none-1"}, {Index: "missing", ID: "c"}, {Index: "idx", ID: "d"}, {Index: "idx", ID: "e"}}
+	res, err := MultiGetAs[testDoc](context.Background(), c, items, WithIncludeFields([]string{"title"}), WithBatchSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&requests) != 3 || len(res) != len(items) {
+		t.Fatalf("expected 3 chunked requests and %d results, got %d %d", len(items), requests, len(res))
+	}
+	for i, item := range items {
+		if res[i].ID != item.ID {
+			t.Fatalf("result %d out of order: %+v", i, res[i])
+		}
+	}
+	if !res[0].Found || res[0].Routing != "r1" || res[0].Source.Title != "a" || res[4].Source.Title != "e" {
+		t.Fatalf("unexpected found doc %+v", res[0])
+	}
+	if res[1].Found || res[1].Error != nil {
+		t.Fatalf("expected not found doc, got %+v", res[1])
+	}
+	if res[2].Found || res[2].Error == nil || res[2].Error.Type != "index_not_found_exception" {
+		t.Fatalf("expected per item error, got %+v", res[2])
+	}
+}
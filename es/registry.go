@@ -0,0 +1,104 @@
+package es
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// registry 按名称保存已初始化的Client，所有读写都需要加锁
+type registry struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
+}
+
+var clients = &registry{clients: make(map[string]*Client)}
+
+// GetClient 根据名称获取Client
+func GetClient(name string) (*Client, bool) {
+	clients.mu.RLock()
+	defer clients.mu.RUnlock()
+	c, ok := clients.clients[name]
+	return c, ok
+}
+
+// MustGetClient 根据名称获取Client，不存在时panic
+func MustGetClient(name string) *Client {
+	c, ok := GetClient(name)
+	if !ok {
+		panic(fmt.Sprintf("es client %q is not initialized", name))
+	}
+	return c
+}
+
+// ListClients 返回已注册的Client名称，按字典序排列
+func ListClients() []string {
+	clients.mu.RLock()
+	defer clients.mu.RUnlock()
+	names := make([]string, 0, len(clients.clients))
+	for name := range clients.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReplaceClient 注册或替换Client，被替换的Client会flush并关闭BulkProcessor
+func ReplaceClient(name string, client *Client) error {
+	if client == nil {
+		return fmt.Errorf("es client %q is nil", name)
+	}
+	client.Name = name
+	clients.mu.Lock()
+	old := clients.clients[name]
+	clients.clients[name] = client
+	clients.mu.Unlock()
+	if old == nil || old == client {
+		return nil
+	}
+	return old.closeBulkProcessor()
+}
+
+// RemoveClient 移除Client，并flush、关闭其BulkProcessor
+func RemoveClient(name string) error {
+	clients.mu.Lock()
+	old, ok := clients.clients[name]
+	delete(clients.clients, name)
+	clients.mu.Unlock()
+	if !ok || old == nil {
+		return nil
+	}
+	return old.closeBulkProcessor()
+}
+
+// snapshot 返回当前全部Client的拷贝，避免持锁执行耗时操作
+func (r *registry) snapshot() map[string]*Client {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cs := make(map[string]*Client, len(r.clients))
+	for name, c := range r.clients {
+		cs[name] = c
+	}
+	return cs
+}
+
+// GetDefaultClient 获取DefaultClient
+func GetDefaultClient() (*Client, bool) {
+	return GetClient(DefaultClient)
+}
+
+// GetReadClient 获取DefaultReadClient，未注册时退回DefaultClient
+func GetReadClient() (*Client, bool) {
+	if c, ok := GetClient(DefaultReadClient); ok {
+		return c, true
+	}
+	return GetDefaultClient()
+}
+
+// GetWriteClient 获取DefaultWriteClient，未注册时退回DefaultClient
+func GetWriteClient() (*Client, bool) {
+	if c, ok := GetClient(DefaultWriteClient); ok {
+		return c, true
+	}
+	return GetDefaultClient()
+}
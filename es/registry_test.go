@@ -0,0 +1,132 @@
+package es
+
+import (
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestReplaceAndRemoveClientCloseBulkProcessor(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	old := newTestBulkClient(t, srv.URL, DefaultBulk())
+	name := old.Name
+	old.BulkCreate("idx", "1", "", map[string]interface{}{"a": 1})
+
+	next := &Client{Bulk: DefaultBulk()}
+	if err := ReplaceClient(name, next); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := GetClient(name); !ok || c != next || next.Name != name {
+		t.Fatalf("expected replaced client to be registered, got %+v", c)
+	}
+	if !old.bulkClosed || srv.Seen("1") != 1 {
+		t.Fatalf("expected old client to be flushed and closed, got closed=%v actions %v", old.bulkClosed, srv.Actions())
+	}
+	//替换为同一个Client时不能关闭它
+	if err := ReplaceClient(name, next); err != nil || next.bulkClosed {
+		t.Fatalf("expected replacing with the same client to keep it open, got %v", err)
+	}
+	if err := ReplaceClient(name, nil); err == nil {
+		t.Fatal("expected nil client error")
+	}
+
+	if err := RemoveClient(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetClient(name); ok || !next.bulkClosed {
+		t.Fatal("expected removed client to be unregistered and closed")
+	}
+	if err := RemoveClient(name); err != nil {
+		t.Fatalf("expected removing a missing client to be a no-op, got %v", err)
+	}
+}
+
+func TestMustGetClientPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r != `es client "registry-missing" is not initialized` {
+			t.Fatalf("unexpected panic %v", r)
+		}
+	}()
+	MustGetClient("registry-missing")
+}
+
+func TestListClientsAndSnapshot(t *testing.T) {
+	for _, name := range []string{"registry-b", "registry-a"} {
+		if err := ReplaceClient(name, &Client{}); err != nil {
+			t.Fatal(err)
+		}
+		defer RemoveClient(name)
+	}
+	names := ListClients()
+	if !sort.StringsAreSorted(names) || len(names) != len(clients.snapshot()) {
+		t.Fatalf("expected sorted names, got %v", names)
+	}
+	snapshot := clients.snapshot()
+	if snapshot["registry-a"] == nil || snapshot["registry-b"] == nil {
+		t.Fatalf("unexpected snapshot %v", snapshot)
+	}
+	delete(snapshot, "registry-a")
+	if _, ok := GetClient("registry-a"); !ok {
+		t.Fatal("snapshot must be a copy")
+	}
+}
+
+func TestReadWriteClientFallback(t *testing.T) {
+	for _, name := range []string{DefaultClient, DefaultReadClient, DefaultWriteClient} {
+		if _, ok := GetClient(name); ok {
+			t.Skipf("%s is registered by another test", name)
+		}
+	}
+	if _, ok := GetReadClient(); ok {
+		t.Fatal("expected no read client")
+	}
+	def := &Client{}
+	if err := ReplaceClient(DefaultClient, def); err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveClient(DefaultClient)
+	if c, ok := GetReadClient(); !ok || c != def {
+		t.Fatal("expected read client to fall back to default client")
+	}
+	if c, ok := GetWriteClient(); !ok || c != def {
+		t.Fatal("expected write client to fall back to default client")
+	}
+
+	read, write := &Client{}, &Client{}
+	ReplaceClient(DefaultReadClient, read)
+	defer RemoveClient(DefaultReadClient)
+	ReplaceClient(DefaultWriteClient, write)
+	defer RemoveClient(DefaultWriteClient)
+	if c, _ := GetReadClient(); c != read {
+		t.Fatal("expected registered read client")
+	}
+	if c, _ := GetWriteClient(); c != write {
+		t.Fatal("expected registered write client")
+	}
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	const name = "registry-concurrent"
+	defer RemoveClient(name)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ReplaceClient(name, &Client{})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if c, ok := GetClient(name); ok && c.Name != name {
+					t.Errorf("unexpected client name %q", c.Name)
+				}
+				ListClients()
+				clients.snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+}
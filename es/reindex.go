@@ -0,0 +1,402 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultReindexPollInterval = 5 * time.Second
+
+type ReindexStep string
+
+const (
+	ReindexStepCreate ReindexStep = "create_index"
+	ReindexStepCopy   ReindexStep = "reindex"
+	ReindexStepVerify ReindexStep = "verify"
+	ReindexStepSwap   ReindexStep = "swap_alias"
+	ReindexStepDelete ReindexStep = "delete_old"
+	ReindexStepDone   ReindexStep = "done"
+)
+
+// ReindexPlan 蓝绿重建索引：创建NewIndex，从别名当前指向的索引reindex数据，校验文档数后切换别名。
+// 旧索引在reindex期间仍可通过别名写入，新写入的文档不会被复制，校验也会因文档数不一致而失败，
+// 因此需要先停止写入，或者开启BlockWrites，或者通过CountTolerance允许一定的差异
+type ReindexPlan struct {
+	Alias    string
+	NewIndex string
+	Body     string        //新索引的settings和mappings
+	Query    elastic.Query //只复制满足条件的文档
+	Script   *elastic.Script
+	// SkipCountCheck 脚本中通过ctx.op丢弃文档时两边的文档数会不一致，需要跳过校验
+	SkipCountCheck bool
+	// BlockWrites 复制前给旧索引设置index.blocks.write，之后对旧索引的写入会失败，保证两边文档数一致。
+	// 别名切换后写入会进入新索引，旧索引不会被解除只读，出错中断时需要手动解除
+	BlockWrites bool
+	// CountTolerance 校验时允许的文档数差异，不开启BlockWrites且无法停止写入时使用
+	CountTolerance int64
+	DeleteOld      bool
+	PollInterval   time.Duration
+	Progress       func(progress ReindexProgress)
+	// StateStore 必填，保存每一步的进度，进程崩溃后使用相同的Alias和NewIndex重新执行即可从中断的步骤继续。
+	// 需要跨进程恢复时使用FileReindexStateStore等持久化的实现，MemoryReindexStateStore只在进程内有效
+	StateStore ReindexStateStore
+}
+
+// ReindexState 重建索引的进度
+type ReindexState struct {
+	Alias    string      `json:"alias"`
+	OldIndex string      `json:"old_index"`
+	NewIndex string      `json:"new_index"`
+	Step     ReindexStep `json:"step"`
+	TaskID   string      `json:"task_id,omitempty"`
+	Updated  time.Time   `json:"updated"`
+}
+
+type ReindexProgress struct {
+	Alias            string
+	Step             ReindexStep
+	TaskID           string
+	Total            int64
+	Created          int64
+	Updated          int64
+	Deleted          int64
+	VersionConflicts int64
+	Noops            int64
+}
+
+// ReindexStateStore 保存重建索引的进度，以别名为key
+type ReindexStateStore interface {
+	Load(alias string) (*ReindexState, error) //不存在时返回nil, nil
+	Save(state *ReindexState) error
+	Delete(alias string) error
+}
+
+// FileReindexStateStore 每个别名的进度保存为dir下的一个JSON文件
+type FileReindexStateStore struct {
+	dir string
+}
+
+func NewFileReindexStateStore(dir string) (*FileReindexStateStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileReindexStateStore{dir: dir}, nil
+}
+
+func (s *FileReindexStateStore) path(alias string) string {
+	return filepath.Join(s.dir, url.PathEscape(alias)+".reindex.json")
+}
+
+func (s *FileReindexStateStore) Load(alias string) (*ReindexState, error) {
+	data, err := os.ReadFile(s.path(alias))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &ReindexState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save 先写临时文件再rename，避免崩溃时留下不完整的文件
+func (s *FileReindexStateStore) Save(state *ReindexState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := s.path(state.Alias) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(state.Alias))
+}
+
+func (s *FileReindexStateStore) Delete(alias string) error {
+	err := os.Remove(s.path(alias))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+type MemoryReindexStateStore struct {
+	states sync.Map
+}
+
+func NewMemoryReindexStateStore() *MemoryReindexStateStore {
+	return &MemoryReindexStateStore{}
+}
+
+func (s *MemoryReindexStateStore) Load(alias string) (*ReindexState, error) {
+	state, ok := s.states.Load(alias)
+	if !ok {
+		return nil, nil
+	}
+	copied := *state.(*ReindexState)
+	return &copied, nil
+}
+
+func (s *MemoryReindexStateStore) Save(state *ReindexState) error {
+	copied := *state
+	s.states.Store(state.Alias, &copied)
+	return nil
+}
+
+func (s *MemoryReindexStateStore) Delete(alias string) error {
+	s.states.Delete(alias)
+	return nil
+}
+
+// BlueGreenReindex 按步骤执行plan，每完成一步保存一次进度，返回最终状态。
+// 出错或ctx取消时进度保留在StateStore中，ES上的reindex任务会继续执行，重新调用会继续轮询该任务
+func (c *Client) BlueGreenReindex(ctx context.Context, plan *ReindexPlan) (*ReindexState, error) {
+	if len(plan.Alias) == 0 || len(plan.NewIndex) == 0 {
+		return nil, errors.New("es: reindex plan requires alias and new index")
+	}
+	store := plan.StateStore
+	if store == nil {
+		return nil, errors.New("es: reindex plan requires a state store")
+	}
+	state, err := store.Load(plan.Alias)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil && state.NewIndex != plan.NewIndex {
+		return state, fmt.Errorf("es: alias %s has an unfinished reindex to %s", plan.Alias, state.NewIndex)
+	}
+	if state == nil {
+		indices, err := c.AliasIndices(ctx, plan.Alias)
+		if err != nil {
+			return nil, err
+		}
+		if len(indices) != 1 {
+			return nil, fmt.Errorf("es: alias %s should point to exactly one index, got %v", plan.Alias, indices)
+		}
+		if indices[0] == plan.NewIndex {
+			return nil, fmt.Errorf("es: alias %s already points to %s", plan.Alias, plan.NewIndex)
+		}
+		state = &ReindexState{Alias: plan.Alias, OldIndex: indices[0], NewIndex: plan.NewIndex, Step: ReindexStepCreate}
+		if err = c.saveReindexState(store, state, state.Step); err != nil {
+			return nil, err
+		}
+	}
+
+	for state.Step != ReindexStepDone {
+		c.reportReindex(plan, ReindexProgress{Alias: state.Alias, Step: state.Step, TaskID: state.TaskID})
+		var next ReindexStep
+		switch state.Step {
+		case ReindexStepCreate:
+			err = c.reindexCreate(ctx, plan)
+			next = ReindexStepCopy
+		case ReindexStepCopy:
+			if err = c.reindexBlockWrites(ctx, plan, state); err == nil {
+				err = c.reindexCopy(ctx, plan, store, state)
+			}
+			next = ReindexStepVerify
+		case ReindexStepVerify:
+			if err = c.reindexBlockWrites(ctx, plan, state); err == nil {
+				err = c.reindexVerify(ctx, plan, state)
+			}
+			next = ReindexStepSwap
+		case ReindexStepSwap:
+			err = c.SwapAlias(ctx, state.Alias, state.NewIndex)
+			next = ReindexStepDone
+			if plan.DeleteOld {
+				next = ReindexStepDelete
+			}
+		case ReindexStepDelete:
+			_, err = c.Client.DeleteIndex(state.OldIndex).Do(ctx)
+			if elastic.IsNotFound(err) {
+				err = nil
+			}
+			c.DeleteIndexCache(state.OldIndex)
+			next = ReindexStepDone
+		default:
+			err = fmt.Errorf("es: unknown reindex step %s", state.Step)
+		}
+		if err != nil {
+			c.logger().Error("es reindex step failed", Any("alias", state.Alias), Any("step", string(state.Step)), Err(err))
+			return state, err
+		}
+		if err = c.saveReindexState(store, state, next); err != nil {
+			return state, err
+		}
+	}
+	c.reportReindex(plan, ReindexProgress{Alias: state.Alias, Step: state.Step, TaskID: state.TaskID})
+	return state, store.Delete(state.Alias)
+}
+
+func (c *Client) saveReindexState(store ReindexStateStore, state *ReindexState, step ReindexStep) error {
+	state.Step = step
+	state.Updated = time.Now()
+	c.logger().Info("es reindex step", Any("alias", state.Alias), Any("old", state.OldIndex), Any("new", state.NewIndex), Any("step", string(step)))
+	return store.Save(state)
+}
+
+func (c *Client) reportReindex(plan *ReindexPlan, progress ReindexProgress) {
+	if plan.Progress != nil {
+		plan.Progress(progress)
+	}
+}
+
+// reindexCreate 新索引已存在说明上一次在创建后崩溃，直接继续
+func (c *Client) reindexCreate(ctx context.Context, plan *ReindexPlan) error {
+	return c.CreateIndex(ctx, plan.NewIndex, plan.Body, true)
+}
+
+// reindexBlockWrites 设置旧索引只读，重复设置是幂等的，从verify步骤恢复时也会再设置一次
+func (c *Client) reindexBlockWrites(ctx context.Context, plan *ReindexPlan, state *ReindexState) error {
+	if !plan.BlockWrites {
+		return nil
+	}
+	_, err := c.Client.IndexPutSettings(state.OldIndex).BodyJson(map[string]interface{}{"index.blocks.write": true}).Do(ctx)
+	return err
+}
+
+// reindexCopy 提交异步reindex任务并轮询到完成。
+// 目标索引使用external版本，任务丢失后重新提交是幂等的
+func (c *Client) reindexCopy(ctx context.Context, plan *ReindexPlan, store ReindexStateStore, state *ReindexState) error {
+	if len(state.TaskID) == 0 {
+		source := elastic.NewReindexSource().Index(state.OldIndex)
+		if plan.Query != nil {
+			source.Query(plan.Query)
+		}
+		reindexService := c.Client.Reindex().Source(source).Conflicts("proceed").
+			Destination(elastic.NewReindexDestination().Index(state.NewIndex).VersionType(DefaultVersionType))
+		if plan.Script != nil {
+			reindexService.Script(plan.Script)
+		}
+		task, err := reindexService.DoAsync(ctx)
+		if err != nil {
+			return err
+		}
+		state.TaskID = task.TaskId
+		if err = c.saveReindexState(store, state, state.Step); err != nil {
+			return err
+		}
+	}
+
+	interval := plan.PollInterval
+	if interval <= 0 {
+		interval = defaultReindexPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		task, err := c.getReindexTask(ctx, state.TaskID)
+		if elastic.IsNotFound(err) {
+			//任务结果丢失，清空TaskID后下次重新提交
+			c.logger().Warn("es reindex task not found", Any("alias", state.Alias), Any("task", state.TaskID))
+			state.TaskID = ""
+			if err = c.saveReindexState(store, state, state.Step); err != nil {
+				return err
+			}
+			return fmt.Errorf("es: reindex task of %s was lost, run again to restart it", state.Alias)
+		}
+		if err != nil {
+			return err
+		}
+		status := task.Task.Status
+		if task.Response != nil {
+			status = *task.Response
+		}
+		c.reportReindex(plan, ReindexProgress{
+			Alias:            state.Alias,
+			Step:             state.Step,
+			TaskID:           state.TaskID,
+			Total:            status.Total,
+			Created:          status.Created,
+			Updated:          status.Updated,
+			Deleted:          status.Deleted,
+			VersionConflicts: status.VersionConflicts,
+			Noops:            status.Noops,
+		})
+		if task.Completed {
+			if task.Error != nil {
+				return &elastic.Error{Details: task.Error}
+			}
+			if len(status.Failures) > 0 {
+				return fmt.Errorf("es: reindex %s to %s has %d failures, first: %s", state.OldIndex, state.NewIndex, len(status.Failures), status.Failures[0])
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type reindexTaskStatus struct {
+	Total            int64             `json:"total"`
+	Created          int64             `json:"created"`
+	Updated          int64             `json:"updated"`
+	Deleted          int64             `json:"deleted"`
+	VersionConflicts int64             `json:"version_conflicts"`
+	Noops            int64             `json:"noops"`
+	Failures         []json.RawMessage `json:"failures"`
+}
+
+type reindexTask struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status reindexTaskStatus `json:"status"`
+	} `json:"task"`
+	Response *reindexTaskStatus    `json:"response"`
+	Error    *elastic.ErrorDetails `json:"error"`
+}
+
+// getReindexTask elastic的TasksGetTaskResponse不包含response字段，无法拿到failures
+func (c *Client) getReindexTask(ctx context.Context, taskID string) (*reindexTask, error) {
+	res, err := c.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_tasks/" + url.PathEscape(taskID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	task := &reindexTask{}
+	if err = json.Unmarshal(res.Body, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// reindexVerify 刷新新索引后比较两边的文档数，plan.Query会同时作用于旧索引，差异不超过plan.CountTolerance即通过
+func (c *Client) reindexVerify(ctx context.Context, plan *ReindexPlan, state *ReindexState) error {
+	if plan.SkipCountCheck {
+		return nil
+	}
+	if _, err := c.Client.Refresh(state.NewIndex).Do(ctx); err != nil {
+		return err
+	}
+	expected, err := c.Count(ctx, state.OldIndex, nil, plan.Query)
+	if err != nil {
+		return err
+	}
+	actual, err := c.Count(ctx, state.NewIndex, nil, nil)
+	if err != nil {
+		return err
+	}
+	diff := expected - actual
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > plan.CountTolerance {
+		return fmt.Errorf("es: reindex count mismatch, %s has %d docs, %s has %d", state.OldIndex, expected, state.NewIndex, actual)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeReindexServer 模拟蓝绿重建索引用到的接口
+type fakeReindexServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	aliases map[string]string //alias -> index
+	indices map[string]int64  //index -> doc count
+	submits int
+	polls   int
+	created []string
+	blocked map[string]int //index -> 第一次设置只读时已提交的reindex任务数
+}
+
+func newFakeReindexServer(t *testing.T) *fakeReindexServer {
+	s := &fakeReindexServer{aliases: map[string]string{"users": "users-v1"}, indices: map[string]int64{"users-v1": 10}, blocked: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		switch {
+		case path == "":
+			w.Write([]byte(`{}`))
+		case strings.HasPrefix(path, "_alias/"):
+			alias := strings.TrimPrefix(path, "_alias/")
+			fmt.Fprintf(w, `{"%s":{"aliases":{"%s":{}}}}`, s.aliases[alias], alias)
+		case path == "_aliases":
+			req := struct {
+				Actions []map[string]map[string]string `json:"actions"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&req)
+			for _, action := range req.Actions {
+				if add, ok := action["add"]; ok {
+					s.aliases[add["alias"]] = add["index"]
+				}
+			}
+			w.Write([]byte(`{"acknowledged":true}`))
+		case path == "_reindex":
+			s.submits++
+			w.Write([]byte(`{"task":"node:1"}`))
+		case strings.HasPrefix(path, "_tasks/"):
+			s.polls++
+			if s.polls < 2 {
+				w.Write([]byte(`{"completed":false,"task":{"status":{"total":10,"created":5}}}`))
+				return
+			}
+			s.indices["users-v2"] = 10
+			w.Write([]byte(`{"completed":true,"task":{"status":{"total":10,"created":10}},"response":{"total":10,"created":10,"failures":[]}}`))
+		case strings.HasSuffix(path, "/_settings") && r.Method == http.MethodPut:
+			body := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if _, ok := s.blocked[strings.TrimSuffix(path, "/_settings")]; !ok && body["index.blocks.write"] == true {
+				s.blocked[strings.TrimSuffix(path, "/_settings")] = s.submits
+			}
+			w.Write([]byte(`{"acknowledged":true}`))
+		case strings.HasSuffix(path, "/_refresh"):
+			w.Write([]byte(`{}`))
+		case strings.HasSuffix(path, "/_count"):
+			fmt.Fprintf(w, `{"count":%d}`, s.indices[strings.TrimSuffix(path, "/_count")])
+		case r.Method == http.MethodHead:
+			if _, ok := s.indices[path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case r.Method == http.MethodPut:
+			s.created = append(s.created, path)
+			s.indices[path] = 0
+			w.Write([]byte(`{"acknowledged":true}`))
+		case r.Method == http.MethodDelete:
+			delete(s.indices, path)
+			w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestBlueGreenReindex(t *testing.T) {
+	srv := newFakeReindexServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	store := NewMemoryReindexStateStore()
+
+	var steps []ReindexStep
+	var lastCreated int64
+	state, err := c.BlueGreenReindex(context.Background(), &ReindexPlan{
+		Alias:        "users",
+		NewIndex:     "users-v2",
+		Body:         `{"mappings":{}}`,
+		DeleteOld:    true,
+		PollInterval: time.Millisecond,
+		StateStore:   store,
+		Progress: func(p ReindexProgress) {
+			if len(steps) == 0 || steps[len(steps)-1] != p.Step {
+				steps = append(steps, p.Step)
+			}
+			if p.Created > 0 {
+				lastCreated = p.Created
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Step != ReindexStepDone || fmt.Sprint(steps) != "[create_index reindex verify swap_alias delete_old done]" || lastCreated != 10 {
+		t.Fatalf("unexpected state %+v steps %v", state, steps)
+	}
+	if srv.aliases["users"] != "users-v2" || srv.indices["users-v1"] != 0 || len(srv.created) != 1 {
+		t.Fatalf("unexpected cluster state %v %v", srv.aliases, srv.indices)
+	}
+	if saved, _ := store.Load("users"); saved != nil {
+		t.Fatalf("expected state to be removed, got %+v", saved)
+	}
+}
+
+func TestBlueGreenReindexResume(t *testing.T) {
+	srv := newFakeReindexServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	store, err := NewFileReindexStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	//旧索引在reindex期间又写入了文档，校验失败
+	srv.indices["users-v1"] = 11
+	plan := &ReindexPlan{Alias: "users", NewIndex: "users-v2", PollInterval: time.Millisecond, StateStore: store}
+	if _, err = c.BlueGreenReindex(context.Background(), plan); err == nil || !strings.Contains(err.Error(), "count mismatch") {
+		t.Fatalf("expected count mismatch, got %v", err)
+	}
+	saved, err := store.Load("users")
+	if err != nil || saved == nil || saved.Step != ReindexStepVerify || saved.TaskID != "node:1" || saved.OldIndex != "users-v1" {
+		t.Fatalf("unexpected saved state %+v %v", saved, err)
+	}
+
+	if _, err = c.BlueGreenReindex(context.Background(), &ReindexPlan{Alias: "users", NewIndex: "users-v2"}); err == nil {
+		t.Fatal("expected missing state store error")
+	}
+	if _, err = c.BlueGreenReindex(context.Background(), &ReindexPlan{Alias: "users", NewIndex: "users-v3", StateStore: store}); err == nil {
+		t.Fatal("expected error for a different unfinished reindex")
+	}
+
+	srv.mu.Lock()
+	srv.indices["users-v2"] = 11
+	srv.mu.Unlock()
+	state, err := c.BlueGreenReindex(context.Background(), plan)
+	if err != nil || state.Step != ReindexStepDone {
+		t.Fatalf("unexpected resume result %+v %v", state, err)
+	}
+	if srv.submits != 1 || len(srv.created) != 1 || srv.aliases["users"] != "users-v2" || srv.indices["users-v1"] != 11 {
+		t.Fatalf("expected resume from verify, submits=%d created=%v aliases=%v", srv.submits, srv.created, srv.aliases)
+	}
+}
+
+func TestBlueGreenReindexBlockWrites(t *testing.T) {
+	srv := newFakeReindexServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	plan := &ReindexPlan{Alias: "users", NewIndex: "users-v2", BlockWrites: true, PollInterval: time.Millisecond, StateStore: NewMemoryReindexStateStore()}
+	state, err := c.BlueGreenReindex(context.Background(), plan)
+	if err != nil || state.Step != ReindexStepDone {
+		t.Fatalf("unexpected result %+v %v", state, err)
+	}
+	submitted, ok := srv.blocked["users-v1"]
+	if !ok || submitted != 0 {
+		t.Fatalf("expected users-v1 to be blocked before reindex, got %v", srv.blocked)
+	}
+	if _, ok = srv.blocked["users-v2"]; ok {
+		t.Fatal("new index should stay writable")
+	}
+}
+
+func TestBlueGreenReindexCountTolerance(t *testing.T) {
+	srv := newFakeReindexServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	//reindex期间旧索引又写入了一篇文档
+	srv.indices["users-v1"] = 11
+	plan := &ReindexPlan{Alias: "users", NewIndex: "users-v2", CountTolerance: 1, PollInterval: time.Millisecond, StateStore: NewMemoryReindexStateStore()}
+	state, err := c.BlueGreenReindex(context.Background(), plan)
+	if err != nil || state.Step != ReindexStepDone {
+		t.Fatalf("unexpected result %+v %v", state, err)
+	}
+	if len(srv.blocked) != 0 {
+		t.Fatalf("unexpected write block %v", srv.blocked)
+	}
+}
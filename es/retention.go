@@ -0,0 +1,211 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type RetentionAction string
+
+const (
+	RetentionClose  RetentionAction = "close"
+	RetentionDelete RetentionAction = "delete"
+)
+
+// RetentionPolicy 分区索引的保留策略，时间从分区结束时开始计算，为0表示不执行该动作。
+// 两者都不为0时DeleteAfter不能早于CloseAfter。
+// 例如CloseAfter为30天、DeleteAfter为90天：30天内可读写，30到90天关闭，90天后删除
+type RetentionPolicy struct {
+	Pattern     *IndexPattern
+	CloseAfter  time.Duration
+	DeleteAfter time.Duration
+}
+
+// RetentionStep 对单个索引执行的动作
+type RetentionStep struct {
+	Index  string
+	Action RetentionAction
+	Age    time.Duration //分区结束至今的时间
+	Err    error
+}
+
+func (p *RetentionPolicy) validate() error {
+	if p == nil {
+		return errors.New("es: retention policy must not be nil")
+	}
+	if p.Pattern == nil || len(p.Pattern.Prefix) == 0 {
+		return errors.New("es: retention policy requires a pattern with prefix")
+	}
+	if p.CloseAfter < 0 || p.DeleteAfter < 0 {
+		return fmt.Errorf("es: retention policy of %s has negative durations", p.Pattern.Prefix)
+	}
+	//DeleteAfter为0表示不删除，否则不能早于关闭
+	if p.DeleteAfter > 0 && p.DeleteAfter < p.CloseAfter {
+		return fmt.Errorf("es: retention policy of %s deletes after %s, earlier than closing after %s", p.Pattern.Prefix, p.DeleteAfter, p.CloseAfter)
+	}
+	return nil
+}
+
+// PlanRetention 根据now计算需要关闭和删除的索引，只处理能被policy.Pattern解析的索引，已关闭的索引不会重复关闭
+func (c *Client) PlanRetention(ctx context.Context, policy *RetentionPolicy, now time.Time) ([]*RetentionStep, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	rows, err := c.Client.CatIndices().Index(policy.Pattern.Wildcard()).Columns("index", "status").Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]*RetentionStep, 0)
+	for _, row := range rows {
+		start, ok := policy.Pattern.Parse(row.Index)
+		if !ok {
+			continue
+		}
+		age := now.Sub(policy.Pattern.next(start))
+		switch {
+		case policy.DeleteAfter > 0 && age >= policy.DeleteAfter:
+			steps = append(steps, &RetentionStep{Index: row.Index, Action: RetentionDelete, Age: age})
+		case policy.CloseAfter > 0 && age >= policy.CloseAfter && row.Status != "close":
+			steps = append(steps, &RetentionStep{Index: row.Index, Action: RetentionClose, Age: age})
+		}
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].Index < steps[j].Index })
+	return steps, nil
+}
+
+// ApplyRetention 执行PlanRetention的结果，dryRun为true时只返回计划。
+// 单个索引失败不影响其他索引，错误记录在对应的RetentionStep中
+func (c *Client) ApplyRetention(ctx context.Context, policy *RetentionPolicy, now time.Time, dryRun bool) ([]*RetentionStep, error) {
+	steps, err := c.PlanRetention(ctx, policy, now)
+	if err != nil || dryRun {
+		return steps, err
+	}
+	var (
+		failed   int
+		firstErr error
+	)
+	for _, step := range steps {
+		switch step.Action {
+		case RetentionDelete:
+			_, step.Err = c.Client.DeleteIndex(step.Index).Do(ctx)
+		case RetentionClose:
+			_, step.Err = c.Client.CloseIndex(step.Index).Do(ctx)
+		}
+		//关闭的索引仍然存在但无法写入，同样从缓存中移除
+		c.DeleteIndexCache(step.Index)
+		if step.Err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = step.Err
+			}
+			c.logger().Error("es retention failed", Any("index", step.Index), Any("action", string(step.Action)), Err(step.Err))
+			continue
+		}
+		c.logger().Info("es retention", Any("index", step.Index), Any("action", string(step.Action)), Any("age", step.Age.String()))
+	}
+	if failed > 0 {
+		return steps, fmt.Errorf("es: %d retention actions failed, first: %w", failed, firstErr)
+	}
+	return steps, nil
+}
+
+// RetentionManager 定时对多个RetentionPolicy执行ApplyRetention
+type RetentionManager struct {
+	client   *Client
+	policies []*RetentionPolicy
+	interval time.Duration
+	dryRun   bool
+
+	mu      sync.Mutex
+	last    []*RetentionStep
+	lastErr error
+}
+
+type RetentionOption func(m *RetentionManager)
+
+func WithRetentionInterval(interval time.Duration) RetentionOption {
+	return func(m *RetentionManager) {
+		m.interval = interval
+	}
+}
+
+// WithRetentionDryRun 只计划不执行，计划通过日志和Last查看
+func WithRetentionDryRun(dryRun bool) RetentionOption {
+	return func(m *RetentionManager) {
+		m.dryRun = dryRun
+	}
+}
+
+func NewRetentionManager(client *Client, policies []*RetentionPolicy, options ...RetentionOption) (*RetentionManager, error) {
+	if client == nil {
+		return nil, errors.New("es: retention manager requires a client")
+	}
+	m := &RetentionManager{client: client, policies: policies, interval: time.Hour}
+	for _, f := range options {
+		if f != nil {
+			f(m)
+		}
+	}
+	if m.interval <= 0 {
+		return nil, fmt.Errorf("es: retention interval must be positive, got %s", m.interval)
+	}
+	for i, policy := range policies {
+		if err := policy.validate(); err != nil {
+			return nil, fmt.Errorf("es: retention policy %d: %w", i, err)
+		}
+	}
+	return m, nil
+}
+
+// RunOnce 依次执行全部策略
+func (m *RetentionManager) RunOnce(ctx context.Context) ([]*RetentionStep, error) {
+	var (
+		all      []*RetentionStep
+		firstErr error
+	)
+	now := time.Now()
+	for _, policy := range m.policies {
+		steps, err := m.client.ApplyRetention(ctx, policy, now, m.dryRun)
+		all = append(all, steps...)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if m.dryRun {
+		for _, step := range all {
+			m.client.logger().Info("es retention dry run", Any("index", step.Index), Any("action", string(step.Action)), Any("age", step.Age.String()))
+		}
+	}
+	m.mu.Lock()
+	m.last, m.lastErr = all, firstErr
+	m.mu.Unlock()
+	return all, firstErr
+}
+
+// Start 立即执行一次，之后按interval执行，ctx取消后停止
+func (m *RetentionManager) Start(ctx context.Context) {
+	go func() {
+		m.RunOnce(ctx)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.RunOnce(ctx)
+			}
+		}
+	}()
+}
+
+// Last 最近一次执行的结果
+func (m *RetentionManager) Last() ([]*RetentionStep, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.last, m.lastErr
+}
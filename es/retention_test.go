@@ -0,0 +1,99 @@
+package es
+
+import (
+	"awesomeProject/timeutil"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/":
+			w.Write([]byte(`{}`))
+		case r.URL.Path == "/_cat/indices/events-*":
+			w.Write([]byte(`[{"index":"events-20260601","status":"open"},{"index":"events-20260901","status":"open"},
+				{"index":"events-20260902","status":"close"},{"index":"events-20261016","status":"open"},
+				{"index":"events-archive","status":"open"},{"index":"events-20200101-backup","status":"open"}]`))
+		default:
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			w.Write([]byte(`{"acknowledged":true}`))
+		}
+	}))
+	defer srv.Close()
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	c.AddIndexCache("events-20260601", "events-20261016")
+	now, _ := timeutil.ParseCSTInLcation("2026-10-17 12:00:00")
+	policy := &RetentionPolicy{
+		Pattern:     NewIndexPattern("events-", PartitionDaily, ""),
+		CloseAfter:  30 * 24 * time.Hour,
+		DeleteAfter: 90 * 24 * time.Hour,
+	}
+
+	steps, err := c.ApplyRetention(context.Background(), policy, now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Index != "events-20260601" || steps[0].Action != RetentionDelete ||
+		steps[1].Index != "events-20260901" || steps[1].Action != RetentionClose {
+		t.Fatalf("unexpected plan %+v %+v", steps[0], steps[1])
+	}
+	if len(calls) != 0 {
+		t.Fatalf("dry run should not touch indices, got %v", calls)
+	}
+
+	if _, err = c.ApplyRetention(context.Background(), policy, now, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "DELETE /events-20260601" || calls[1] != "POST /events-20260901/_close" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	if _, ok := c.CacheIndices.Load("events-20260601"); ok {
+		t.Fatal("expected deleted index to be removed from cache")
+	}
+	if _, ok := c.CacheIndices.Load("events-20261016"); !ok {
+		t.Fatal("expected hot index to stay in cache")
+	}
+}
+
+func TestNewRetentionManagerValidation(t *testing.T) {
+	c := &Client{}
+	policy := &RetentionPolicy{Pattern: &IndexPattern{Prefix: "logs-", Interval: PartitionDaily}, DeleteAfter: 24 * time.Hour}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{policy}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{policy}, WithRetentionInterval(0)); err == nil {
+		t.Fatal("expected zero interval error")
+	}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{policy}, WithRetentionInterval(-time.Minute)); err == nil {
+		t.Fatal("expected negative interval error")
+	}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{policy, nil}); err == nil {
+		t.Fatal("expected nil policy error")
+	}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{{}}); err == nil {
+		t.Fatal("expected missing pattern error")
+	}
+	pattern := &IndexPattern{Prefix: "logs-", Interval: PartitionDaily}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{{Pattern: pattern, CloseAfter: 48 * time.Hour, DeleteAfter: 24 * time.Hour}}); err == nil {
+		t.Fatal("expected delete before close error")
+	}
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{{Pattern: pattern, DeleteAfter: -time.Hour}}); err == nil {
+		t.Fatal("expected negative duration error")
+	}
+	//只关闭不删除
+	if _, err := NewRetentionManager(c, []*RetentionPolicy{{Pattern: pattern, CloseAfter: 48 * time.Hour}}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,418 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/olivere/elastic/v7"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	healthCheckTimeout         = 3 * time.Second
+)
+
+// RWClient 读写分离客户端，查询走读集群(协调节点)，写入走写集群(ingest节点)
+type RWClient struct {
+	// 已注册到registry的Client按名称跟踪，配置热更新替换Client后自动使用新的实例
+	readName  string
+	writeName string
+	read      *Client
+	write     *Client
+
+	fallback            bool          //读集群不健康时是否退回写集群
+	healthCheckInterval time.Duration //读集群健康状态缓存时间
+
+	mu          sync.Mutex
+	readHealthy bool
+	lastCheck   time.Time
+	probing     chan struct{} //正在进行的健康检查，同一时间只有一个
+}
+
+type RWOption func(*RWClient)
+
+func WithReadFallback(fallback bool) RWOption {
+	return func(c *RWClient) {
+		c.fallback = fallback
+	}
+}
+
+func WithHealthCheckInterval(interval time.Duration) RWOption {
+	return func(c *RWClient) {
+		c.healthCheckInterval = interval
+	}
+}
+
+func NewRWClient(read, write *Client, options ...RWOption) (*RWClient, error) {
+	if read == nil || write == nil {
+		return nil, errors.New("es: read and write client must not be nil")
+	}
+	c := &RWClient{
+		readName:            registeredName(read),
+		writeName:           registeredName(write),
+		read:                read,
+		write:               write,
+		healthCheckInterval: defaultHealthCheckInterval,
+		readHealthy:         true,
+	}
+	for _, f := range options {
+		if f != nil {
+			f(c)
+		}
+	}
+	return c, nil
+}
+
+// registeredName Client已注册时返回其名称，未注册的Client始终使用传入的实例
+func registeredName(c *Client) string {
+	if registered, ok := GetClient(c.Name); ok && registered == c {
+		return c.Name
+	}
+	return ""
+}
+
+// Read 返回读集群的Client，注册的Client被替换后返回替换后的实例
+func (c *RWClient) Read() *Client {
+	return lookupClient(c.readName, c.read)
+}
+
+// Write 返回写集群的Client，注册的Client被替换后返回替换后的实例
+func (c *RWClient) Write() *Client {
+	return lookupClient(c.writeName, c.write)
+}
+
+func lookupClient(name string, fallback *Client) *Client {
+	if len(name) > 0 {
+		if client, ok := GetClient(name); ok {
+			return client
+		}
+	}
+	return fallback
+}
+
+// DefaultRWClient 使用DefaultReadClient和DefaultWriteClient构造读写分离客户端
+func DefaultRWClient(options ...RWOption) (*RWClient, error) {
+	read, ok := GetReadClient()
+	if !ok {
+		return nil, errors.New("es: read client is not initialized")
+	}
+	write, ok := GetWriteClient()
+	if !ok {
+		return nil, errors.New("es: write client is not initialized")
+	}
+	return NewRWClient(read, write, options...)
+}
+
+// reader 返回本次读请求使用的Client
+func (c *RWClient) reader(ctx context.Context) *Client {
+	if !c.fallback || c.Read() == c.Write() {
+		return c.Read()
+	}
+	if c.isReadHealthy(ctx) {
+		return c.Read()
+	}
+	return c.Write()
+}
+
+// isReadHealthy 健康检查不持有锁也不使用调用方的ctx，检查期间其他请求直接使用上一次的结果，
+// 调用方的ctx结束时不再等待检查结果
+func (c *RWClient) isReadHealthy(ctx context.Context) bool {
+	c.mu.Lock()
+	healthy := c.readHealthy
+	if c.probing != nil || time.Since(c.lastCheck) < c.healthCheckInterval {
+		c.mu.Unlock()
+		return healthy
+	}
+	done := make(chan struct{})
+	c.probing = done
+	c.mu.Unlock()
+
+	go c.probeRead(done)
+	select {
+	case <-done:
+		c.mu.Lock()
+		healthy = c.readHealthy
+		c.mu.Unlock()
+		return healthy
+	case <-ctx.Done():
+		return healthy
+	}
+}
+
+func (c *RWClient) probeRead(done chan struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	res, err := c.Read().Client.ClusterHealth().Do(ctx)
+	healthy := err == nil && res != nil && res.Status != "red"
+	c.mu.Lock()
+	c.readHealthy = healthy
+	c.lastCheck = time.Now()
+	c.probing = nil
+	c.mu.Unlock()
+	if !healthy {
+		c.Read().logger().Warn("read cluster is unhealthy, fallback to write cluster", Any("read", c.Read().Name), Any("write", c.Write().Name), Err(err))
+	}
+}
+
+// markReadUnhealthy 读请求出现连接错误时标记读集群不可用，直到下一次健康检查
+func (c *RWClient) markReadUnhealthy() {
+	c.mu.Lock()
+	c.readHealthy = false
+	c.lastCheck = time.Now()
+	c.mu.Unlock()
+}
+
+// shouldRetryOnWrite 判断读请求失败后是否需要在写集群上重试
+func (c *RWClient) shouldRetryOnWrite(used *Client, err error) bool {
+	if !c.fallback || used == c.Write() || err == nil {
+		return false
+	}
+	if errors.Is(err, elastic.ErrNoClient) || elastic.IsConnErr(err) {
+		c.markReadUnhealthy()
+		return true
+	}
+	return false
+}
+
+func (c *RWClient) Get(ctx context.Context, indexName, id, routing string) (*elastic.GetResult, error) {
+	client := c.reader(ctx)
+	res, err := client.Get(ctx, indexName, id, routing)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().Get(ctx, indexName, id, routing)
+	}
+	return res, err
+}
+
+func (c *RWClient) MultiGet(ctx context.Context, items []Mget, options ...QueryOption) ([]*elastic.GetResult, error) {
+	client := c.reader(ctx)
+	res, err := client.MultiGet(ctx, items, options...)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().MultiGet(ctx, items, options...)
+	}
+	return res, err
+}
+
+func (c *RWClient) Query(ctx context.Context, indexName string, routes []string, query elastic.Query, from, size int, options ...QueryOption) (*elastic.SearchResult, error) {
+	client := c.reader(ctx)
+	res, err := client.Query(ctx, indexName, routes, query, from, size, options...)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().Query(ctx, indexName, routes, query, from, size, options...)
+	}
+	return res, err
+}
+
+// QueryAfter PIT只存在于打开它的集群，翻页期间不能切换集群，因此固定走读集群
+func (c *RWClient) QueryAfter(ctx context.Context, indexName string, routes []string, query elastic.Query, cursor string, size int, options ...QueryOption) (*CursorPage, error) {
+	return c.Read().QueryAfter(ctx, indexName, routes, query, cursor, size, options...)
+}
+
+func (c *RWClient) ClosePointInTime(ctx context.Context, cursor string) error {
+	return c.Read().ClosePointInTime(ctx, cursor)
+}
+
+func (c *RWClient) Count(ctx context.Context, indexName string, routes []string, query elastic.Query, options ...QueryOption) (int64, error) {
+	client := c.reader(ctx)
+	count, err := client.Count(ctx, indexName, routes, query, options...)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().Count(ctx, indexName, routes, query, options...)
+	}
+	return count, err
+}
+
+func (c *RWClient) ExistsByQuery(ctx context.Context, indexName string, routes []string, query elastic.Query, options ...QueryOption) (bool, error) {
+	client := c.reader(ctx)
+	exists, err := client.ExistsByQuery(ctx, indexName, routes, query, options...)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().ExistsByQuery(ctx, indexName, routes, query, options...)
+	}
+	return exists, err
+}
+
+func (c *RWClient) MultiSearch(ctx context.Context, requests []*MultiSearchRequest) ([]*MultiSearchResult, error) {
+	client := c.reader(ctx)
+	res, err := client.MultiSearch(ctx, requests)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().MultiSearch(ctx, requests)
+	}
+	return res, err
+}
+
+func (c *RWClient) ScrollQuery(ctx context.Context, index []string, typeStr string, query elastic.Query, size int, routes []string, callback func(res *elastic.SearchResult, err error), options ...QueryOption) {
+	c.reader(ctx).ScrollQuery(ctx, index, typeStr, query, size, routes, callback, options...)
+}
+
+func (c *RWClient) Scroll(ctx context.Context, index []string, query elastic.Query, size int, routes []string, options ...QueryOption) *ScrollIterator {
+	return c.reader(ctx).Scroll(ctx, index, query, size, routes, options...)
+}
+
+func (c *RWClient) ScrollChan(ctx context.Context, index []string, query elastic.Query, size int, routes []string, options ...QueryOption) (<-chan *elastic.SearchHit, <-chan error) {
+	return c.reader(ctx).ScrollChan(ctx, index, query, size, routes, options...)
+}
+
+func (c *RWClient) SlicedScroll(ctx context.Context, index []string, query elastic.Query, size, slices int, routes []string, handler func(slice int, it *ScrollIterator) error, options ...QueryOption) error {
+	return c.reader(ctx).SlicedScroll(ctx, index, query, size, slices, routes, handler, options...)
+}
+
+func (c *RWClient) SlicedScrollQuery(ctx context.Context, index []string, query elastic.Query, size, slices int, routes []string, callback func(slice int, res *elastic.SearchResult) error, options ...QueryOption) error {
+	return c.reader(ctx).SlicedScrollQuery(ctx, index, query, size, slices, routes, callback, options...)
+}
+
+func (c *RWClient) IndexExists(ctx context.Context, indexName string, forceCheck bool) (bool, error) {
+	client := c.reader(ctx)
+	exists, err := client.IndexExists(ctx, indexName, forceCheck)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().IndexExists(ctx, indexName, forceCheck)
+	}
+	return exists, err
+}
+
+func (c *RWClient) QueryRange(ctx context.Context, pattern *IndexPattern, from, to time.Time, routes []string, query elastic.Query, offset, size int, options ...QueryOption) (*elastic.SearchResult, error) {
+	client := c.reader(ctx)
+	res, err := client.QueryRange(ctx, pattern, from, to, routes, query, offset, size, options...)
+	if c.shouldRetryOnWrite(client, err) {
+		return c.Write().QueryRange(ctx, pattern, from, to, routes, query, offset, size, options...)
+	}
+	return res, err
+}
+
+func (c *RWClient) PatternIndex(ctx context.Context, pattern *IndexPattern, t time.Time) (string, error) {
+	return c.Write().PatternIndex(ctx, pattern, t)
+}
+
+func (c *RWClient) CreateIndex(ctx context.Context, indexName, bodyJson string, forceCheck bool) error {
+	return c.Write().CreateIndex(ctx, indexName, bodyJson, forceCheck)
+}
+
+func (c *RWClient) AddAlias(ctx context.Context, indexName, alias string, options ...AliasOption) error {
+	return c.Write().AddAlias(ctx, indexName, alias, options...)
+}
+
+func (c *RWClient) RemoveAlias(ctx context.Context, indexName, alias string) error {
+	return c.Write().RemoveAlias(ctx, indexName, alias)
+}
+
+func (c *RWClient) SwapAlias(ctx context.Context, alias, indexName string, options ...AliasOption) error {
+	return c.Write().SwapAlias(ctx, alias, indexName, options...)
+}
+
+func (c *RWClient) AliasIndices(ctx context.Context, alias string) ([]string, error) {
+	return c.Write().AliasIndices(ctx, alias)
+}
+
+func (c *RWClient) WriteIndex(ctx context.Context, alias string) (string, error) {
+	return c.Write().WriteIndex(ctx, alias)
+}
+
+func (c *RWClient) SetWriteIndex(ctx context.Context, alias, indexName string) error {
+	return c.Write().SetWriteIndex(ctx, alias, indexName)
+}
+
+func (c *RWClient) PutTemplate(ctx context.Context, t *Template) error {
+	return c.Write().PutTemplate(ctx, t)
+}
+
+func (c *RWClient) GetTemplate(ctx context.Context, kind TemplateKind, name string) (*Template, error) {
+	return c.Write().GetTemplate(ctx, kind, name)
+}
+
+func (c *RWClient) DeleteTemplate(ctx context.Context, kind TemplateKind, name string) error {
+	return c.Write().DeleteTemplate(ctx, kind, name)
+}
+
+func (c *RWClient) DiffTemplate(ctx context.Context, t *Template) ([]string, error) {
+	return c.Write().DiffTemplate(ctx, t)
+}
+
+func (c *RWClient) EnsureTemplates(ctx context.Context, templates ...*Template) error {
+	return c.Write().EnsureTemplates(ctx, templates...)
+}
+
+func (c *RWClient) GetMapping(ctx context.Context, indexName string) (*Mapping, error) {
+	return c.Write().GetMapping(ctx, indexName)
+}
+
+func (c *RWClient) DiffMapping(ctx context.Context, indexName string, desired *Mapping) (*MappingDiff, error) {
+	return c.Write().DiffMapping(ctx, indexName, desired)
+}
+
+func (c *RWClient) PutMapping(ctx context.Context, indexName string, desired *Mapping) (*MappingDiff, error) {
+	return c.Write().PutMapping(ctx, indexName, desired)
+}
+
+func (c *RWClient) Create(ctx context.Context, indexName, id, routing string, doc interface{}) error {
+	return c.Write().Create(ctx, indexName, id, routing, doc)
+}
+
+func (c *RWClient) Update(ctx context.Context, indexName, id, routing string, update map[string]interface{}) error {
+	return c.Write().Update(ctx, indexName, id, routing, update)
+}
+
+func (c *RWClient) UpdateRefresh(ctx context.Context, indexName, id, routing string, update map[string]interface{}) error {
+	return c.Write().UpdateRefresh(ctx, indexName, id, routing, update)
+}
+
+func (c *RWClient) UpdateQuery(ctx context.Context, indexName string, routings []string, query elastic.Query, script string, scriptParams map[string]interface{}) (*elastic.BulkIndexByScrollResponse, error) {
+	return c.Write().UpdateQuery(ctx, indexName, routings, query, script, scriptParams)
+}
+
+func (c *RWClient) Upsert(ctx context.Context, indexName, id, routing string, update map[string]interface{}, doc interface{}) error {
+	return c.Write().Upsert(ctx, indexName, id, routing, update, doc)
+}
+
+func (c *RWClient) UpsertWithVersion(ctx context.Context, indexName, id, routing string, doc interface{}, version int64) error {
+	return c.Write().UpsertWithVersion(ctx, indexName, id, routing, doc, version)
+}
+
+func (c *RWClient) Delete(ctx context.Context, indexName, id, routing string) error {
+	return c.Write().Delete(ctx, indexName, id, routing)
+}
+
+func (c *RWClient) DeleteRefresh(ctx context.Context, indexName, id, routing string) error {
+	return c.Write().DeleteRefresh(ctx, indexName, id, routing)
+}
+
+func (c *RWClient) DeleteWithVersion(ctx context.Context, indexName, id, routing string, version int64) error {
+	return c.Write().DeleteWithVersion(ctx, indexName, id, routing, version)
+}
+
+func (c *RWClient) DeleteByQuery(ctx context.Context, indexName, id, routing string, query elastic.Query) error {
+	return c.Write().DeleteByQuery(ctx, indexName, id, routing, query)
+}
+
+func (c *RWClient) BulkCreate(indexName, id, routing string, doc interface{}) {
+	c.Write().BulkCreate(indexName, id, routing, doc)
+}
+
+func (c *RWClient) BulkCreateDocs(ctx context.Context, indexName string, docs []*BulkCreateDoc) (*elastic.BulkResponse, error) {
+	return c.Write().BulkCreateDocs(ctx, indexName, docs)
+}
+
+func (c *RWClient) BulkCreateWithVersion(ctx context.Context, indexName, id, routing string, version int64, doc interface{}) {
+	c.Write().BulkCreateWithVersion(ctx, indexName, id, routing, version, doc)
+}
+
+func (c *RWClient) BulkDelete(indexName, id, routing string, version int64) {
+	c.Write().BulkDelete(indexName, id, routing, version)
+}
+
+func (c *RWClient) BulkDeleteWithVersion(indexName, id, routing string, version int64) {
+	c.Write().BulkDeleteWithVersion(indexName, id, routing, version)
+}
+
+func (c *RWClient) BulkUpdate(indexName, id, routing string, update map[string]interface{}) {
+	c.Write().BulkUpdate(indexName, id, routing, update)
+}
+
+func (c *RWClient) BulkUpdateDocs(ctx context.Context, index string, updates []*BulkUpdateDoc) (*elastic.BulkResponse, error) {
+	return c.Write().BulkUpdateDocs(ctx, index, updates)
+}
+
+func (c *RWClient) BulkUpsert(indexName, id, routing string, update map[string]interface{}, doc interface{}) {
+	c.Write().BulkUpsert(indexName, id, routing, update, doc)
+}
+
+func (c *RWClient) BulkUpsertDocs(ctx context.Context, index string, docs []*BulkUpsertDoc) (*elastic.BulkResponse, error) {
+	return c.Write().BulkUpsertDocs(ctx, index, docs)
+}
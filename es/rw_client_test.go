@@ -0,0 +1,101 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHealthServer 返回指定状态的集群健康信息，block不为nil时健康检查会阻塞到block关闭
+type fakeHealthServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	status string
+	block  chan struct{}
+	checks int
+}
+
+func newFakeHealthServer(t *testing.T, status string) *fakeHealthServer {
+	s := &fakeHealthServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_cluster/health" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		s.mu.Lock()
+		s.checks++
+		block, status := s.block, s.status
+		s.mu.Unlock()
+		if block != nil {
+			<-block
+		}
+		fmt.Fprintf(w, `{"cluster_name":"test","status":%q}`, status)
+	}))
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+func (s *fakeHealthServer) set(status string, block chan struct{}) {
+	s.mu.Lock()
+	s.status, s.block = status, block
+	s.mu.Unlock()
+}
+
+func newTestRWClient(t *testing.T, read *fakeHealthServer, interval time.Duration) *RWClient {
+	write := newFakeHealthServer(t, "green")
+	c, err := NewRWClient(newTestBulkClient(t, read.URL, DefaultBulk()), newTestBulkClient(t, write.URL, DefaultBulk()),
+		WithReadFallback(true), WithHealthCheckInterval(interval))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRWClientFallbackAndRecovery(t *testing.T) {
+	read := newFakeHealthServer(t, "red")
+	c := newTestRWClient(t, read, 20*time.Millisecond)
+	ctx := context.Background()
+	if c.reader(ctx) != c.Write() {
+		t.Fatal("expected fallback to write cluster when read cluster is red")
+	}
+	read.set("yellow", nil)
+	if c.reader(ctx) != c.Write() {
+		t.Fatal("expected cached health within check interval")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if c.reader(ctx) != c.Read() {
+		t.Fatal("expected read cluster to recover after next health check")
+	}
+}
+
+func TestRWClientHealthCheckDoesNotBlockReads(t *testing.T) {
+	read := newFakeHealthServer(t, "green")
+	c := newTestRWClient(t, read, time.Hour)
+	block := make(chan struct{})
+	read.set("red", block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if c.reader(ctx) != c.Read() {
+		t.Fatal("expected cached healthy state when caller ctx ends during health check")
+	}
+	start := time.Now()
+	if c.reader(context.Background()) != c.Read() || time.Since(start) > 10*time.Millisecond {
+		t.Fatal("expected concurrent reads to use cached state without waiting")
+	}
+	close(block)
+	time.Sleep(20 * time.Millisecond)
+	if c.reader(ctx) != c.Write() {
+		t.Fatal("expected probe result to be stored after it completes")
+	}
+	read.mu.Lock()
+	defer read.mu.Unlock()
+	if read.checks != 1 {
+		t.Fatalf("expected a single health check, got %d", read.checks)
+	}
+}
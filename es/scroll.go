@@ -0,0 +1,215 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scrollClearTimeout 清理scroll上下文的超时时间，清理不使用调用方的ctx，保证ctx取消后也能释放
+const scrollClearTimeout = 10 * time.Second
+
+// ScrollIterator 逐条遍历scroll查询结果，遍历结束、出错或调用Close后都会清理ES中的scroll上下文
+//
+//	it := client.Scroll(ctx, []string{"idx"}, query, 1000, nil)
+//	defer it.Close()
+//	for it.Next() {
+//		hit := it.Doc()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ScrollIterator struct {
+	c        *Client
+	ctx      context.Context
+	service  *elastic.ScrollService
+	queryOpt *queryOption
+	index    string
+	routes   []string
+	dsl      []byte
+
+	page  *elastic.SearchResult
+	pos   int
+	hit   *elastic.SearchHit
+	err   error
+	total int64
+	count int64
+
+	onPage    func(it *ScrollIterator) //每拉取一页后调用
+	closeOnce sync.Once
+	closeErr  error
+	closed    bool
+}
+
+// Scroll 返回scroll查询的迭代器，调用方需要在结束后调用Close
+func (c *Client) Scroll(ctx context.Context, index []string, query elastic.Query, size int, routes []string, options ...QueryOption) *ScrollIterator {
+	return c.newScrollIterator(ctx, index, query, size, routes, newQueryOption(options), nil)
+}
+
+func (c *Client) newScrollIterator(ctx context.Context, index []string, query elastic.Query, size int, routes []string, queryOpt *queryOption, slice *elastic.SliceQuery) *ScrollIterator {
+	searchSource := queryOpt.searchSource(query)
+	logMsg := "es scroll query"
+	if slice != nil {
+		searchSource.Slice(slice)
+		logMsg = "es sliced scroll query"
+	}
+	src, _ := searchSource.Source()
+	data, _ := json.Marshal(src)
+	indexName := strings.Join(index, ",")
+	if c.DebugMode || c.QueryLogEnable || queryOpt.EnableDSL {
+		c.logger().Info(logMsg, Any("index", indexName), Any("dsl", string(data)), Any("routing", strings.Join(routes, ",")))
+	}
+	scrollService := c.Client.Scroll(index...).SearchSource(searchSource).Size(size)
+	if len(routes) > 0 {
+		scrollService.Routing(routes...)
+	}
+	if len(queryOpt.KeepAlive) > 0 {
+		scrollService.KeepAlive(queryOpt.KeepAlive)
+	}
+	scrollService.Preference(queryOpt.preference())
+	return &ScrollIterator{
+		c:        c,
+		ctx:      ctx,
+		service:  scrollService,
+		queryOpt: queryOpt,
+		index:    indexName,
+		routes:   routes,
+		dsl:      data,
+	}
+}
+
+// Next 移动到下一条文档，没有更多文档或出错时返回false并自动Close
+func (it *ScrollIterator) Next() bool {
+	for it.page == nil || it.pos >= len(it.page.Hits.Hits) {
+		if !it.nextPage() {
+			return false
+		}
+	}
+	it.hit = it.page.Hits.Hits[it.pos]
+	it.pos++
+	return true
+}
+
+// nextPage 拉取下一页结果
+func (it *ScrollIterator) nextPage() bool {
+	if it.closed || it.err != nil {
+		return false
+	}
+	if it.err = it.ctx.Err(); it.err != nil {
+		it.Close()
+		return false
+	}
+	res, err := it.service.Do(it.ctx)
+	if err == io.EOF {
+		it.Close()
+		return false
+	}
+	it.c.recordSlowQuery(it.queryOpt, it.index, it.routes, it.dsl, res)
+	if err != nil {
+		//ctx取消时elastic返回的是包装后的url.Error，统一返回ctx的错误
+		if ctxErr := it.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		it.err = err
+		it.Close()
+		return false
+	}
+	if res == nil || res.Hits == nil {
+		it.err = fmt.Errorf("es: scroll query on %s got empty response", it.index)
+		it.Close()
+		return false
+	}
+	if len(res.Hits.Hits) == 0 {
+		it.Close()
+		return false
+	}
+	if res.Hits.TotalHits != nil {
+		it.total = res.Hits.TotalHits.Value
+	}
+	it.count += int64(len(res.Hits.Hits))
+	it.page = res
+	it.pos = 0
+	if it.onPage != nil {
+		it.onPage(it)
+	}
+	return true
+}
+
+// Doc 当前文档
+func (it *ScrollIterator) Doc() *elastic.SearchHit {
+	return it.hit
+}
+
+// Page 当前文档所在的一页结果
+func (it *ScrollIterator) Page() *elastic.SearchResult {
+	return it.page
+}
+
+// Total 命中总数，拉取第一页之后才有值
+func (it *ScrollIterator) Total() int64 {
+	return it.total
+}
+
+// Fetched 已经从ES拉取的文档数
+func (it *ScrollIterator) Fetched() int64 {
+	return it.count
+}
+
+// Err 遍历过程中的错误，正常结束时为nil
+func (it *ScrollIterator) Err() error {
+	return it.err
+}
+
+// Close 停止遍历并清理scroll上下文，可以重复调用
+func (it *ScrollIterator) Close() error {
+	it.closeOnce.Do(func() {
+		it.closed = true
+		ctx, cancel := context.WithTimeout(context.Background(), scrollClearTimeout)
+		defer cancel()
+		//scroll保存在ES集群中的上下文信息会占用大量内存资源，虽然会在一段时间后自动清理，当我们知道scroll结束后,
+		//需要手动调用clear释放资源
+		if it.closeErr = it.service.Clear(ctx); it.closeErr != nil {
+			it.c.logger().Warn("clear scroll error", Any("index", it.index), Err(it.closeErr))
+		}
+	})
+	return it.closeErr
+}
+
+// ScrollChan 在后台遍历scroll查询结果并写入返回的channel，消费变慢时停止拉取。
+// 遍历结束后hits被关闭，随后errC返回遍历错误(可能为nil)；提前停止需要取消ctx，scroll上下文同样会被清理
+//
+//	hits, errC := client.ScrollChan(ctx, []string{"idx"}, query, 1000, nil)
+//	for hit := range hits {
+//		...
+//	}
+//	if err := <-errC; err != nil {
+//		...
+//	}
+func (c *Client) ScrollChan(ctx context.Context, index []string, query elastic.Query, size int, routes []string, options ...QueryOption) (<-chan *elastic.SearchHit, <-chan error) {
+	return scrollChan(ctx, c.Scroll(ctx, index, query, size, routes, options...))
+}
+
+func scrollChan(ctx context.Context, it *ScrollIterator) (<-chan *elastic.SearchHit, <-chan error) {
+	hits := make(chan *elastic.SearchHit)
+	errC := make(chan error, 1)
+	go func() {
+		defer close(errC)
+		defer close(hits)
+		defer it.Close()
+		for it.Next() {
+			select {
+			case hits <- it.Doc():
+			case <-ctx.Done():
+				errC <- ctx.Err()
+				return
+			}
+		}
+		errC <- it.Err()
+	}()
+	return hits, errC
+}
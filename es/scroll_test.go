@@ -0,0 +1,134 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeScrollServer 每页返回一条文档，共pages页，failAt>0时第failAt页返回500
+type fakeScrollServer struct {
+	*httptest.Server
+	pages   int
+	failAt  int
+	fetched int64
+	mu      sync.Mutex
+	cleared []string
+}
+
+func newFakeScrollServer(t *testing.T, pages, failAt int) *fakeScrollServer {
+	s := &fakeScrollServer{pages: pages, failAt: failAt}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodDelete {
+			s.mu.Lock()
+			s.cleared = append(s.cleared, r.URL.Path)
+			s.mu.Unlock()
+			w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "_search") && !strings.HasSuffix(r.URL.Path, "/scroll") {
+			w.Write([]byte(`{}`))
+			return
+		}
+		page := int(atomic.AddInt64(&s.fetched, 1))
+		if page == s.failAt {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"type":"search_phase_execution_exception","reason":"boom"},"status":500}`))
+			return
+		}
+		hits := ""
+		if page <= s.pages {
+			hits = fmt.Sprintf(`{"_index":"idx","_id":"%d","_source":{"title":"t%d","count":%d}}`, page, page, page)
+		}
+		fmt.Fprintf(w, `{"_scroll_id":"scroll-1","hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, s.pages, hits)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeScrollServer) Cleared() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cleared)
+}
+
+func TestScrollIterator(t *testing.T) {
+	srv := newFakeScrollServer(t, 3, 0)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	it := ScrollAs[testDoc](c.Scroll(context.Background(), []string{"idx"}, nil, 1, nil))
+	var counts []int
+	for it.Next() {
+		counts = append(counts, it.Doc().Source.Count)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(counts) != "[1 2 3]" || it.Total() != 3 || it.Fetched() != 3 {
+		t.Fatalf("unexpected docs %v total %d", counts, it.Total())
+	}
+	it.Close()
+	if srv.Cleared() != 1 {
+		t.Fatalf("expected scroll to be cleared once, got %d", srv.Cleared())
+	}
+}
+
+func TestScrollIteratorError(t *testing.T) {
+	srv := newFakeScrollServer(t, 3, 2)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	it := c.Scroll(context.Background(), []string{"idx"}, nil, 1, nil)
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if it.Err() == nil || n != 1 {
+		t.Fatalf("expected error after first doc, got %d docs err %v", n, it.Err())
+	}
+	if srv.Cleared() != 1 {
+		t.Fatal("expected scroll to be cleared after error")
+	}
+
+	srv = newFakeScrollServer(t, 3, 2)
+	c = newTestBulkClient(t, srv.URL, DefaultBulk())
+	var errs, pages int
+	c.ScrollQuery(context.Background(), []string{"idx"}, "", nil, 1, nil, func(res *elastic.SearchResult, err error) {
+		if err != nil {
+			errs++
+			return
+		}
+		pages++
+	})
+	if errs != 1 || pages != 1 || srv.Cleared() != 1 {
+		t.Fatalf("expected scroll query to stop on error, got %d pages %d errors", pages, errs)
+	}
+}
+
+func TestScrollChanEarlyStop(t *testing.T) {
+	srv := newFakeScrollServer(t, 100, 0)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hits, errC := c.ScrollChan(ctx, []string{"idx"}, nil, 1, nil)
+	<-hits
+	<-hits
+	cancel()
+	for range hits {
+	}
+	if err := <-errC; err != context.Canceled {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	if srv.Cleared() != 1 {
+		t.Fatal("expected scroll to be cleared after cancel")
+	}
+	if fetched := atomic.LoadInt64(&srv.fetched); fetched > 4 {
+		t.Fatalf("expected backpressure to stop fetching, fetched %d pages", fetched)
+	}
+}
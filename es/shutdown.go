@@ -0,0 +1,130 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ShutdownError 汇总各Client关闭时的错误以及未写入ES的文档数
+type ShutdownError struct {
+	Errors    map[string]error
+	Unflushed map[string]int64
+}
+
+func (e *ShutdownError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %v (unflushed %d)", name, e.Errors[name], e.Unflushed[name]))
+	}
+	return "es shutdown: " + strings.Join(msgs, "; ")
+}
+
+// Total 全部Client未写入ES的文档数
+func (e *ShutdownError) Total() int64 {
+	var total int64
+	for _, n := range e.Unflushed {
+		total += n
+	}
+	return total
+}
+
+// unflushed 已提交但还未写入ES的文档数，包含等待重试的文档
+func (c *Client) unflushed() int64 {
+	pending := atomic.LoadInt64(&c.bulkPending)
+	if pending < 0 {
+		pending = 0
+	}
+	return pending + atomic.LoadInt64(&c.retrying)
+}
+
+// Shutdown flush并关闭BulkProcessor，最多等待到ctx结束，退避中的重试会直接写入死信。
+// 返回未写入ES的文档数，超时后BulkProcessor仍会在后台继续关闭
+func (c *Client) Shutdown(ctx context.Context) (int64, error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.closeBulkProcessor()
+	}()
+	select {
+	case err := <-done:
+		unflushed := atomic.LoadInt64(&c.retrying)
+		if err == nil && unflushed > 0 {
+			err = fmt.Errorf("%d actions were waiting for retry", unflushed)
+		}
+		return unflushed, err
+	case <-ctx.Done():
+		return c.unflushed(), ctx.Err()
+	}
+}
+
+// ShutdownAll 并发关闭全部已注册的Client，返回*ShutdownError
+func ShutdownAll(ctx context.Context) error {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		errs    = make(map[string]error)
+		pending = make(map[string]int64)
+	)
+	for name, c := range clients.snapshot() {
+		if c == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, c *Client) {
+			defer wg.Done()
+			unflushed, err := c.Shutdown(ctx)
+			if err == nil {
+				return
+			}
+			c.logger().Error("es client shutdown error", Any("client", name), Any("unflushed", unflushed), Err(err))
+			mu.Lock()
+			errs[name] = err
+			pending[name] = unflushed
+			mu.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return &ShutdownError{Errors: errs, Unflushed: pending}
+	}
+	return nil
+}
+
+// HandleShutdownSignals 收到信号(默认SIGTERM、SIGINT)后在timeout内flush并关闭全部Client，
+// 结果写入返回的channel，由调用方决定何时退出进程
+//
+//	done := es.HandleShutdownSignals(10 * time.Second)
+//	...
+//	if err := <-done; err != nil {
+//		log.Println(err)
+//	}
+func HandleShutdownSignals(timeout time.Duration, signals ...os.Signal) <-chan error {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	}
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, signals...)
+	done := make(chan error, 1)
+	go func() {
+		sig := <-sigC
+		signal.Stop(sigC)
+		getLogger().Info("es clients draining bulk buffers", Any("signal", sig.String()), Any("timeout", timeout.String()))
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		done <- ShutdownAll(ctx)
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,81 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/olivere/elastic/v7"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientShutdownDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte(`{"took":1,"errors":false,"items":[{"create":{"_index":"idx","_id":"1","status":201}},{"create":{"_index":"idx","_id":"2","status":201}}]}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	bulk := DefaultBulk()
+	bulk.Workers = 1
+	c := newTestBulkClient(t, srv.URL, bulk)
+
+	c.BulkCreate("idx", "1", "", map[string]interface{}{"a": 1})
+	c.BulkCreate("idx", "2", "", map[string]interface{}{"a": 2})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	unflushed, err := c.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || unflushed != 2 {
+		t.Fatalf("expected 2 unflushed actions and deadline error, got %d %v", unflushed, err)
+	}
+
+	unflushed, err = c.Shutdown(context.Background())
+	if err != nil || unflushed != 0 {
+		t.Fatalf("expected clean shutdown, got %d %v", unflushed, err)
+	}
+	queue := NewMemoryDeadLetterQueue(0)
+	c.Bulk.DeadLetter = queue
+	c.BulkCreate("idx", "3", "", map[string]interface{}{"a": 3})
+	if queue.Len() != 1 {
+		t.Fatal("expected action added after shutdown to be dead lettered")
+	}
+}
+
+func TestClientShutdownDrainsPendingRetries(t *testing.T) {
+	srv := newFakeBulkServer(t)
+	queue := NewMemoryDeadLetterQueue(0)
+	bulk := DefaultBulk()
+	bulk.DeadLetter = queue
+	bulk.FailurePolicies[FailureRetryable].Backoff = elastic.NewConstantBackoff(5 * time.Second)
+	c := newTestBulkClient(t, srv.URL, bulk)
+
+	c.BulkCreate("idx", "busy-1", "", map[string]interface{}{"a": 1})
+	if err := c.BulkProcessor.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&c.retrying) != 1 {
+		t.Fatal("expected a retry waiting for backoff")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	unflushed, err := c.Shutdown(ctx)
+	if err != nil || unflushed != 0 {
+		t.Fatalf("expected clean shutdown, got %d %v", unflushed, err)
+	}
+	entries := queue.Drain()
+	if len(entries) != 1 || entries[0].ID != "busy-1" {
+		t.Fatalf("expected pending retry to be dead lettered before Shutdown returns, got %+v", entries)
+	}
+	if srv.Seen("busy-1") != 1 {
+		t.Fatalf("unexpected actions %v", srv.Actions())
+	}
+}
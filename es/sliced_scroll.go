@@ -0,0 +1,96 @@
+package es
+
+import (
+	"context"
+	"errors"
+	"github.com/olivere/elastic/v7"
+	"sync"
+)
+
+// SliceProgress 单个slice的进度
+type SliceProgress struct {
+	Slice   int
+	Slices  int
+	Fetched int64 //该slice已拉取的文档数
+	Total   int64 //该slice的命中总数
+	Done    bool
+	Err     error
+}
+
+// WithSliceProgress 设置sliced scroll的进度回调，每个slice每拉取一页以及结束时各回调一次，
+// 多个slice会并发回调
+func WithSliceProgress(progress func(progress SliceProgress)) QueryOption {
+	return func(opt *queryOption) {
+		opt.SliceProgress = progress
+	}
+}
+
+// SlicedScroll 将scroll查询切分为slices个slice并发执行，每个slice的迭代器交给handler处理。
+// handler返回错误、任意slice出错或ctx取消时停止全部slice，所有slice的scroll上下文都会被清理，返回第一个错误
+//
+//	err := client.SlicedScroll(ctx, []string{"idx"}, query, 1000, 8, nil, func(slice int, it *es.ScrollIterator) error {
+//		for it.Next() {
+//			...
+//		}
+//		return nil
+//	})
+func (c *Client) SlicedScroll(ctx context.Context, index []string, query elastic.Query, size, slices int, routes []string, handler func(slice int, it *ScrollIterator) error, options ...QueryOption) error {
+	if slices < 1 {
+		return errors.New("es: slices must be greater than 0")
+	}
+	queryOpt := newQueryOption(options)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < slices; i++ {
+		var slice *elastic.SliceQuery
+		//ES要求slice的max大于1
+		if slices > 1 {
+			slice = elastic.NewSliceQuery().Id(i).Max(slices)
+		}
+		it := c.newScrollIterator(ctx, index, query, size, routes, queryOpt, slice)
+		if queryOpt.SliceProgress != nil {
+			id := i
+			it.onPage = func(it *ScrollIterator) {
+				queryOpt.SliceProgress(SliceProgress{Slice: id, Slices: slices, Fetched: it.Fetched(), Total: it.Total()})
+			}
+		}
+		wg.Add(1)
+		go func(id int, it *ScrollIterator) {
+			defer wg.Done()
+			defer it.Close()
+			err := handler(id, it)
+			if err == nil {
+				err = it.Err()
+			}
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+			if queryOpt.SliceProgress != nil {
+				queryOpt.SliceProgress(SliceProgress{Slice: id, Slices: slices, Fetched: it.Fetched(), Total: it.Total(), Done: true, Err: err})
+			}
+		}(i, it)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// SlicedScrollQuery 与SlicedScroll相同，按页回调每个slice的结果
+func (c *Client) SlicedScrollQuery(ctx context.Context, index []string, query elastic.Query, size, slices int, routes []string, callback func(slice int, res *elastic.SearchResult) error, options ...QueryOption) error {
+	return c.SlicedScroll(ctx, index, query, size, slices, routes, func(slice int, it *ScrollIterator) error {
+		for it.nextPage() {
+			if err := callback(slice, it.Page()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, options...)
+}
@@ -0,0 +1,130 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newFakeSlicedScrollServer 每个slice返回pages页，每页一条文档，返回值记录被清理的scroll id
+func newFakeSlicedScrollServer(t *testing.T, pages int) (*httptest.Server, func() []string) {
+	var (
+		mu      sync.Mutex
+		served  = make(map[string]int)
+		cleared []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := io.ReadAll(r.Body)
+		req := struct {
+			ScrollID interface{} `json:"scroll_id"`
+			Slice    *struct {
+				ID  int `json:"id"`
+				Max int `json:"max"`
+			} `json:"slice"`
+		}{}
+		json.Unmarshal(body, &req)
+		mu.Lock()
+		defer mu.Unlock()
+		var scrollID string
+		switch {
+		case r.Method == http.MethodDelete:
+			cleared = append(cleared, fmt.Sprint(req.ScrollID))
+			w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+			return
+		case strings.HasSuffix(r.URL.Path, "/_search/scroll"):
+			scrollID = fmt.Sprint(req.ScrollID)
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			if req.Slice == nil {
+				t.Errorf("expected slice in body: %s", body)
+				return
+			}
+			scrollID = fmt.Sprintf("slice-%d", req.Slice.ID)
+		default:
+			w.Write([]byte(`{}`))
+			return
+		}
+		served[scrollID]++
+		hits := ""
+		if served[scrollID] <= pages {
+			hits = fmt.Sprintf(`{"_index":"idx","_id":"%s-%d","_source":{}}`, scrollID, served[scrollID])
+		}
+		fmt.Fprintf(w, `{"_scroll_id":"%s","hits":{"total":{"value":%d,"relation":"eq"},"hits":[%s]}}`, scrollID, pages, hits)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), cleared...)
+	}
+}
+
+func TestSlicedScroll(t *testing.T) {
+	srv, cleared := newFakeSlicedScrollServer(t, 2)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	var (
+		mu   sync.Mutex
+		ids  = make(map[string]int)
+		done = make(map[int]SliceProgress)
+	)
+	err := c.SlicedScroll(context.Background(), []string{"idx"}, nil, 1, 3, nil, func(slice int, it *ScrollIterator) error {
+		for it.Next() {
+			mu.Lock()
+			ids[it.Doc().Id] = slice
+			mu.Unlock()
+		}
+		return nil
+	}, WithSliceProgress(func(p SliceProgress) {
+		if p.Done {
+			mu.Lock()
+			done[p.Slice] = p
+			mu.Unlock()
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 6 || ids["slice-2-2"] != 2 {
+		t.Fatalf("unexpected docs %v", ids)
+	}
+	if len(done) != 3 || done[1].Fetched != 2 || done[1].Total != 2 || done[1].Err != nil {
+		t.Fatalf("unexpected progress %v", done)
+	}
+	if len(cleared()) != 3 {
+		t.Fatalf("expected every slice to be cleared, got %v", cleared())
+	}
+}
+
+func TestSlicedScrollStopsOnError(t *testing.T) {
+	srv, cleared := newFakeSlicedScrollServer(t, 1000)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+
+	//slice 0在其他slice都拿到scroll id之后再返回错误
+	var started sync.WaitGroup
+	started.Add(2)
+	first := make([]sync.Once, 3)
+	boom := errors.New("boom")
+	err := c.SlicedScrollQuery(context.Background(), []string{"idx"}, nil, 1, 3, nil, func(slice int, res *elastic.SearchResult) error {
+		if slice == 0 {
+			started.Wait()
+			return boom
+		}
+		first[slice].Do(started.Done)
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+	if len(cleared()) != 3 {
+		t.Fatalf("expected every slice to be cleared, got %v", cleared())
+	}
+}
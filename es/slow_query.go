@@ -0,0 +1,159 @@
+package es
+
+import (
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowQueryRecord 慢查询记录
+type SlowQueryRecord struct {
+	Client    string
+	Index     string
+	Routing   string
+	DSL       string
+	Took      int64 //ES返回的took，单位毫秒
+	TotalHits int64
+	Caller    string //发起查询的调用方，file:line
+	Time      time.Time
+}
+
+// SlowQuerySink 慢查询记录的接收方
+type SlowQuerySink interface {
+	Record(record *SlowQueryRecord)
+}
+
+type SlowQuerySinkFunc func(record *SlowQueryRecord)
+
+func (f SlowQuerySinkFunc) Record(record *SlowQueryRecord) {
+	f(record)
+}
+
+type loggerSlowQuerySink struct {
+	logger Logger
+}
+
+// NewLoggerSlowQuerySink 输出到日志，logger为nil时使用SetLogger设置的Logger
+func NewLoggerSlowQuerySink(logger Logger) SlowQuerySink {
+	return &loggerSlowQuerySink{logger: logger}
+}
+
+func (s *loggerSlowQuerySink) Record(r *SlowQueryRecord) {
+	logger := s.logger
+	if logger == nil {
+		logger = getLogger()
+	}
+	logger.Warn("slow query",
+		Any("client", r.Client),
+		Any("index", r.Index),
+		Any("routing", r.Routing),
+		Any("took_ms", r.Took),
+		Any("total_hits", r.TotalHits),
+		Any("caller", r.Caller),
+		Any("dsl", r.DSL),
+	)
+}
+
+// ChanSlowQuerySink 写入channel，channel满时丢弃记录，不阻塞查询
+type ChanSlowQuerySink chan *SlowQueryRecord
+
+func (s ChanSlowQuerySink) Record(record *SlowQueryRecord) {
+	select {
+	case s <- record:
+	default:
+	}
+}
+
+// RingSlowQuerySink 保留最近size条慢查询记录
+type RingSlowQuerySink struct {
+	mu      sync.Mutex
+	records []*SlowQueryRecord
+	next    int
+	full    bool
+}
+
+func NewRingSlowQuerySink(size int) *RingSlowQuerySink {
+	if size <= 0 {
+		size = 100
+	}
+	return &RingSlowQuerySink{records: make([]*SlowQueryRecord, size)}
+}
+
+func (s *RingSlowQuerySink) Record(record *SlowQueryRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[s.next] = record
+	s.next = (s.next + 1) % len(s.records)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Records 按时间先后返回当前保留的记录
+func (s *RingSlowQuerySink) Records() []*SlowQueryRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]*SlowQueryRecord(nil), s.records[:s.next]...)
+	}
+	res := make([]*SlowQueryRecord, 0, len(s.records))
+	res = append(res, s.records[s.next:]...)
+	return append(res, s.records[:s.next]...)
+}
+
+func WithSlowQuerySink(sink SlowQuerySink) Option {
+	return func(o *option) {
+		o.SlowQuerySink = sink
+	}
+}
+
+// slowQueryThreshold 单次查询的WithSlowQueryMillisecond优先于客户端的全局配置，小于0表示关闭
+func (c *Client) slowQueryThreshold(queryOpt *queryOption) int64 {
+	if queryOpt.SlowQueryMillisecond != 0 {
+		return queryOpt.SlowQueryMillisecond
+	}
+	return c.SlowQueryMillisecond
+}
+
+func (c *Client) recordSlowQuery(queryOpt *queryOption, index string, routes []string, dsl []byte, res *elastic.SearchResult) {
+	threshold := c.slowQueryThreshold(queryOpt)
+	if threshold <= 0 || res == nil || res.TookInMillis < threshold {
+		return
+	}
+	record := &SlowQueryRecord{
+		Client:  c.Name,
+		Index:   index,
+		Routing: strings.Join(routes, ","),
+		DSL:     string(dsl),
+		Took:    res.TookInMillis,
+		Caller:  caller(),
+		Time:    time.Now(),
+	}
+	if res.Hits != nil && res.Hits.TotalHits != nil {
+		record.TotalHits = res.Hits.TotalHits.Value
+	}
+	sink := c.SlowQuerySink
+	if sink == nil {
+		sink = NewLoggerSlowQuerySink(c.logger())
+	}
+	sink.Record(record)
+}
+
+var pkgPath = reflect.TypeOf((*Client)(nil)).Elem().PkgPath()
+
+// caller 返回es包之外的第一个调用方
+func caller() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !more || !strings.HasPrefix(frame.Function, pkgPath+".") || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+	}
+}
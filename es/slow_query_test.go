@@ -0,0 +1,34 @@
+package es
+
+import (
+	"github.com/olivere/elastic/v7"
+	"strings"
+	"testing"
+)
+
+func TestRecordSlowQuery(t *testing.T) {
+	sink := NewRingSlowQuerySink(2)
+	c := &Client{Name: "slow", SlowQueryMillisecond: 100, SlowQuerySink: sink}
+	res := &elastic.SearchResult{TookInMillis: 150, Hits: &elastic.SearchHits{TotalHits: &elastic.TotalHits{Value: 7}}}
+
+	c.recordSlowQuery(&queryOption{}, "idx-1", []string{"r1", "r2"}, []byte(`{}`), res)
+	c.recordSlowQuery(&queryOption{SlowQueryMillisecond: 200}, "idx-2", nil, nil, res)
+	c.recordSlowQuery(&queryOption{SlowQueryMillisecond: -1}, "idx-3", nil, nil, res)
+	c.recordSlowQuery(&queryOption{SlowQueryMillisecond: 50}, "idx-4", nil, nil, res)
+	c.recordSlowQuery(&queryOption{}, "idx-5", nil, nil, res)
+
+	records := sink.Records()
+	if len(records) != 2 || records[0].Index != "idx-4" || records[1].Index != "idx-5" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	c.SlowQuerySink = ChanSlowQuerySink(make(chan *SlowQueryRecord, 1))
+	c.recordSlowQuery(&queryOption{}, "idx-1", []string{"r1", "r2"}, []byte(`{}`), res)
+	r := <-c.SlowQuerySink.(ChanSlowQuerySink)
+	if r.Routing != "r1,r2" || r.TotalHits != 7 || r.Took != 150 || r.Client != "slow" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if !strings.Contains(r.Caller, "slow_query_test.go") {
+		t.Fatalf("unexpected caller %s", r.Caller)
+	}
+}
@@ -0,0 +1,341 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+	"io/fs"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+type TemplateKind string
+
+const (
+	IndexTemplate     TemplateKind = "index_template"
+	ComponentTemplate TemplateKind = "component_template"
+)
+
+// TemplateHashKey EnsureTemplate写入_meta的内容hash
+const TemplateHashKey = "content_hash"
+
+// Template 可组合索引模板或组件模板，Body为PUT请求的内容
+type Template struct {
+	Kind TemplateKind
+	Name string
+	Body json.RawMessage
+}
+
+// NewTemplate body可以是结构体、map、JSON字符串或[]byte
+func NewTemplate(kind TemplateKind, name string, body interface{}) (*Template, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch b := body.(type) {
+	case string:
+		data = []byte(b)
+	case []byte:
+		data = b
+	case json.RawMessage:
+		data = b
+	default:
+		if data, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("es: marshal template %s: %w", name, err)
+		}
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("es: template %s is not valid json", name)
+	}
+	return &Template{Kind: kind, Name: name, Body: data}, nil
+}
+
+// LoadTemplates 从dir/component_template/*.json和dir/index_template/*.json加载模板，文件名为模板名，
+// 组件模板排在前面，可以配合embed.FS使用
+//
+//	//go:embed templates
+//	var templates embed.FS
+//	list, err := es.LoadTemplates(templates, "templates")
+func LoadTemplates(fsys fs.FS, dir string) ([]*Template, error) {
+	templates := make([]*Template, 0)
+	for _, kind := range []TemplateKind{ComponentTemplate, IndexTemplate} {
+		files, err := fs.Glob(fsys, path.Join(dir, string(kind), "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			data, err := fs.ReadFile(fsys, file)
+			if err != nil {
+				return nil, err
+			}
+			t, err := NewTemplate(kind, strings.TrimSuffix(path.Base(file), ".json"), data)
+			if err != nil {
+				return nil, err
+			}
+			templates = append(templates, t)
+		}
+	}
+	return templates, nil
+}
+
+// Hash 模板内容的sha256，不包含_meta中的hash本身，与JSON的格式和key的顺序无关
+func (t *Template) Hash() (string, error) {
+	body, err := t.decode()
+	if err != nil {
+		return "", err
+	}
+	if meta, ok := body["_meta"].(map[string]interface{}); ok {
+		delete(meta, TemplateHashKey)
+		if len(meta) == 0 {
+			delete(body, "_meta")
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (t *Template) decode() (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(t.Body))
+	decoder.UseNumber()
+	body := make(map[string]interface{})
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("es: decode template %s: %w", t.Name, err)
+	}
+	return body, nil
+}
+
+// hashedBody 在_meta中写入内容hash
+func (t *Template) hashedBody() (json.RawMessage, string, error) {
+	hash, err := t.Hash()
+	if err != nil {
+		return nil, "", err
+	}
+	body, err := t.decode()
+	if err != nil {
+		return nil, "", err
+	}
+	meta, ok := body["_meta"].(map[string]interface{})
+	if !ok {
+		meta = make(map[string]interface{})
+		body["_meta"] = meta
+	}
+	meta[TemplateHashKey] = hash
+	data, err := json.Marshal(body)
+	return data, hash, err
+}
+
+func (t *Template) liveHash() string {
+	body, err := t.decode()
+	if err != nil {
+		return ""
+	}
+	meta, _ := body["_meta"].(map[string]interface{})
+	hash, _ := meta[TemplateHashKey].(string)
+	return hash
+}
+
+func (c *Client) PutTemplate(ctx context.Context, t *Template) error {
+	return c.putTemplate(ctx, t.Kind, t.Name, t.Body)
+}
+
+func (c *Client) putTemplate(ctx context.Context, kind TemplateKind, name string, body json.RawMessage) error {
+	var err error
+	switch kind {
+	case IndexTemplate:
+		_, err = c.Client.IndexPutIndexTemplate(name).BodyString(string(body)).Do(ctx)
+	case ComponentTemplate:
+		_, err = c.Client.IndexPutComponentTemplate(name).BodyString(string(body)).Do(ctx)
+	default:
+		err = fmt.Errorf("es: unknown template kind %s", kind)
+	}
+	return err
+}
+
+// GetTemplate 模板不存在时返回nil, nil
+func (c *Client) GetTemplate(ctx context.Context, kind TemplateKind, name string) (*Template, error) {
+	if kind != IndexTemplate && kind != ComponentTemplate {
+		return nil, fmt.Errorf("es: unknown template kind %s", kind)
+	}
+	res, err := c.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/_" + string(kind) + "/" + url.PathEscape(name),
+	})
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	//{"index_templates":[{"name":"x","index_template":{...}}]}
+	list := make(map[string][]map[string]json.RawMessage)
+	if err = json.Unmarshal(res.Body, &list); err != nil {
+		return nil, err
+	}
+	for _, item := range list[string(kind)+"s"] {
+		var itemName string
+		json.Unmarshal(item["name"], &itemName)
+		if itemName == name {
+			return &Template{Kind: kind, Name: name, Body: item[string(kind)]}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) DeleteTemplate(ctx context.Context, kind TemplateKind, name string) error {
+	var err error
+	switch kind {
+	case IndexTemplate:
+		_, err = c.Client.IndexDeleteIndexTemplate(name).Do(ctx)
+	case ComponentTemplate:
+		_, err = c.Client.IndexDeleteComponentTemplate(name).Do(ctx)
+	default:
+		err = fmt.Errorf("es: unknown template kind %s", kind)
+	}
+	return err
+}
+
+// DiffTemplate 比较t与ES中的模板，返回不一致的字段路径，模板不存在时返回整个模板为新增。
+// ES返回的settings会被规范化为{"index":{...}}且数值变为字符串，比较前两边的settings都展开为
+// index.开头的点分key，数字和字符串按字面值比较
+func (c *Client) DiffTemplate(ctx context.Context, t *Template) ([]string, error) {
+	live, err := c.GetTemplate(ctx, t.Kind, t.Name)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := t.decode()
+	if err != nil {
+		return nil, err
+	}
+	if live == nil {
+		return []string{"+ " + t.Name}, nil
+	}
+	current, err := live.decode()
+	if err != nil {
+		return nil, err
+	}
+	for _, body := range []map[string]interface{}{desired, current} {
+		if meta, ok := body["_meta"].(map[string]interface{}); ok {
+			delete(meta, TemplateHashKey)
+			if len(meta) == 0 {
+				delete(body, "_meta")
+			}
+		}
+		if template, ok := body["template"].(map[string]interface{}); ok {
+			if settings, ok := template["settings"].(map[string]interface{}); ok {
+				flat := make(map[string]interface{}, len(settings))
+				flattenSettings("", settings, flat)
+				template["settings"] = flat
+			}
+		}
+	}
+	diffs := make([]string, 0)
+	diffJSON("", current, desired, &diffs)
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// EnsureTemplate 只有内容hash与ES中不同时才写入模板，返回是否写入，适合每个服务启动时调用
+func (c *Client) EnsureTemplate(ctx context.Context, t *Template) (bool, error) {
+	body, hash, err := t.hashedBody()
+	if err != nil {
+		return false, err
+	}
+	live, err := c.GetTemplate(ctx, t.Kind, t.Name)
+	if err != nil {
+		return false, err
+	}
+	if live != nil && live.liveHash() == hash {
+		return false, nil
+	}
+	if err = c.putTemplate(ctx, t.Kind, t.Name, body); err != nil {
+		return false, err
+	}
+	c.logger().Info("es template updated", Any("kind", string(t.Kind)), Any("name", t.Name), Any("hash", hash))
+	return true, nil
+}
+
+// EnsureTemplates 按顺序执行EnsureTemplate，组件模板需要排在引用它的索引模板前面
+func (c *Client) EnsureTemplates(ctx context.Context, templates ...*Template) error {
+	for _, t := range templates {
+		if _, err := c.EnsureTemplate(ctx, t); err != nil {
+			return fmt.Errorf("es: ensure %s %s: %w", t.Kind, t.Name, err)
+		}
+	}
+	return nil
+}
+
+// diffJSON 递归比较两个JSON值，- 表示只在current中，+ 表示只在desired中，~ 表示值不同
+func diffJSON(prefix string, current, desired interface{}, diffs *[]string) {
+	currentMap, ok1 := current.(map[string]interface{})
+	desiredMap, ok2 := desired.(map[string]interface{})
+	if ok1 && ok2 {
+		for key, value := range desiredMap {
+			p := joinPath(prefix, key)
+			if cur, ok := currentMap[key]; ok {
+				diffJSON(p, cur, value, diffs)
+			} else {
+				*diffs = append(*diffs, "+ "+p)
+			}
+		}
+		for key := range currentMap {
+			if _, ok := desiredMap[key]; !ok {
+				*diffs = append(*diffs, "- "+joinPath(prefix, key))
+			}
+		}
+		return
+	}
+	if scalarEqual(current, desired) {
+		return
+	}
+	*diffs = append(*diffs, fmt.Sprintf("~ %s: %v -> %v", prefix, jsonString(current), jsonString(desired)))
+}
+
+// flattenSettings 将{"index":{"refresh_interval":"5s"}}、{"refresh_interval":"5s"}等写法统一为{"index.refresh_interval":"5s"}
+func flattenSettings(prefix string, settings map[string]interface{}, flat map[string]interface{}) {
+	for key, value := range settings {
+		key = joinPath(prefix, key)
+		if m, ok := value.(map[string]interface{}); ok {
+			flattenSettings(key, m, flat)
+			continue
+		}
+		if !strings.HasPrefix(key, "index.") {
+			key = "index." + key
+		}
+		flat[key] = value
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if len(prefix) == 0 {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func scalarEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case string, json.Number, bool:
+		switch b.(type) {
+		case string, json.Number, bool:
+			return fmt.Sprint(a) == fmt.Sprint(b)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,154 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func newFakeTemplateServer(t *testing.T) (*httptest.Server, *int) {
+	var (
+		mu        sync.Mutex
+		templates = make(map[string]string)
+		puts      int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/_"), "/")
+		if len(parts) != 2 {
+			w.Write([]byte(`{}`))
+			return
+		}
+		kind, key := parts[0], r.URL.Path
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			templates[key] = string(body)
+			puts++
+			w.Write([]byte(`{"acknowledged":true}`))
+		case http.MethodDelete:
+			delete(templates, key)
+			w.Write([]byte(`{"acknowledged":true}`))
+		case http.MethodGet:
+			body, ok := templates[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":{"type":"resource_not_found_exception","reason":"missing"},"status":404}`))
+				return
+			}
+			fmt.Fprintf(w, `{"%ss":[{"name":"%s","%s":%s}]}`, kind, parts[1], kind, normalizeTestSettings(body))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &puts
+}
+
+// normalizeTestSettings 模拟ES返回的settings：统一放在index下按点分key嵌套，值都是字符串
+func normalizeTestSettings(body string) string {
+	doc := make(map[string]interface{})
+	json.Unmarshal([]byte(body), &doc)
+	template, _ := doc["template"].(map[string]interface{})
+	settings, _ := template["settings"].(map[string]interface{})
+	if settings == nil {
+		return body
+	}
+	flat := make(map[string]interface{})
+	flattenSettings("", settings, flat)
+	nested := make(map[string]interface{})
+	for key, value := range flat {
+		parts := strings.Split(key, ".")
+		m := nested
+		for _, part := range parts[:len(parts)-1] {
+			if m[part] == nil {
+				m[part] = make(map[string]interface{})
+			}
+			m = m[part].(map[string]interface{})
+		}
+		m[parts[len(parts)-1]] = fmt.Sprint(value)
+	}
+	template["settings"] = nested
+	data, _ := json.Marshal(doc)
+	return string(data)
+}
+
+func TestLoadTemplates(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/index_template/events.json":       {Data: []byte(`{"index_patterns":["events-*"],"composed_of":["base"]}`)},
+		"templates/component_template/base.json":     {Data: []byte(`{"template":{"settings":{"number_of_shards":1}}}`)},
+		"templates/component_template/ignore.txt":    {Data: []byte(`x`)},
+		"templates/component_template/mappings.json": {Data: []byte(`{"template":{"mappings":{}}}`)},
+	}
+	templates, err := LoadTemplates(fsys, "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 3 || templates[0].Name != "base" || templates[1].Name != "mappings" ||
+		templates[2].Kind != IndexTemplate || templates[2].Name != "events" {
+		t.Fatalf("unexpected templates %+v", templates)
+	}
+}
+
+func TestEnsureTemplate(t *testing.T) {
+	srv, puts := newFakeTemplateServer(t)
+	c := newTestBulkClient(t, srv.URL, DefaultBulk())
+	ctx := context.Background()
+
+	type settings struct {
+		Template map[string]interface{} `json:"template"`
+		Meta     map[string]string      `json:"_meta"`
+	}
+	base, err := NewTemplate(ComponentTemplate, "base", settings{
+		Template: map[string]interface{}{"settings": map[string]interface{}{"number_of_shards": 1}},
+		Meta:     map[string]string{"owner": "search"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _ := NewTemplate(IndexTemplate, "events", `{"index_patterns":["events-*"],"composed_of":["base"],"priority":10}`)
+	if err = c.EnsureTemplates(ctx, base, index); err != nil || *puts != 2 {
+		t.Fatalf("expected both templates to be put, got %d %v", *puts, err)
+	}
+
+	//key的顺序不同，内容相同
+	same, _ := NewTemplate(IndexTemplate, "events", `{"priority":10,"composed_of":["base"],"index_patterns":["events-*"]}`)
+	if updated, err := c.EnsureTemplate(ctx, same); err != nil || updated || *puts != 2 {
+		t.Fatalf("expected unchanged template to be skipped, got %v %v", updated, err)
+	}
+	if diffs, err := c.DiffTemplate(ctx, base); err != nil || len(diffs) != 0 {
+		t.Fatalf("expected no diff, got %v %v", diffs, err)
+	}
+
+	changed, _ := NewTemplate(ComponentTemplate, "base", `{"template":{"settings":{"number_of_shards":2,"refresh_interval":"5s"}}}`)
+	diffs, err := c.DiffTemplate(ctx, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"+ template.settings.index.refresh_interval", "- _meta", `~ template.settings.index.number_of_shards: "1" -> 2`}
+	if fmt.Sprint(diffs) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected diff %q", diffs)
+	}
+	if updated, err := c.EnsureTemplate(ctx, changed); err != nil || !updated || *puts != 3 {
+		t.Fatalf("expected changed template to be put, got %v %v", updated, err)
+	}
+	//nested、点分key和不带index前缀的写法等价
+	dotted, _ := NewTemplate(ComponentTemplate, "base", `{"template":{"settings":{"index.number_of_shards":2,"index":{"refresh_interval":"5s"}}}}`)
+	if diffs, err := c.DiffTemplate(ctx, dotted); err != nil || len(diffs) != 0 {
+		t.Fatalf("expected equivalent settings to have no diff, got %v %v", diffs, err)
+	}
+
+	if err = c.DeleteTemplate(ctx, IndexTemplate, "events"); err != nil {
+		t.Fatal(err)
+	}
+	if live, err := c.GetTemplate(ctx, IndexTemplate, "events"); err != nil || live != nil {
+		t.Fatalf("expected deleted template, got %+v %v", live, err)
+	}
+}
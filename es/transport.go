@@ -0,0 +1,250 @@
+package es
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	pinPrefix                  = "sha256/"
+)
+
+// transportOption http连接池以及TLS相关配置
+type transportOption struct {
+	CAFiles             []string
+	CAPEMs              [][]byte
+	ClientCertFile      string
+	ClientKeyFile       string
+	ClientCertificates  []tls.Certificate
+	ServerName          string
+	CertificatePins     []string
+	InsecureSkipVerify  bool
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	configured          bool
+}
+
+// WithCACertFile 使用指定的CA证书文件校验服务端证书
+func WithCACertFile(files ...string) Option {
+	return func(o *option) {
+		o.Transport.CAFiles = append(o.Transport.CAFiles, files...)
+		o.Transport.configured = true
+	}
+}
+
+// WithCACertPEM 使用PEM格式的CA证书校验服务端证书
+func WithCACertPEM(pems ...[]byte) Option {
+	return func(o *option) {
+		o.Transport.CAPEMs = append(o.Transport.CAPEMs, pems...)
+		o.Transport.configured = true
+	}
+}
+
+// WithClientCertFile 双向认证(mTLS)时使用的客户端证书
+func WithClientCertFile(certFile, keyFile string) Option {
+	return func(o *option) {
+		o.Transport.ClientCertFile = certFile
+		o.Transport.ClientKeyFile = keyFile
+		o.Transport.configured = true
+	}
+}
+
+func WithClientCertificate(certs ...tls.Certificate) Option {
+	return func(o *option) {
+		o.Transport.ClientCertificates = append(o.Transport.ClientCertificates, certs...)
+		o.Transport.configured = true
+	}
+}
+
+// WithServerName 覆盖证书校验使用的域名，通过IP访问集群时使用
+func WithServerName(serverName string) Option {
+	return func(o *option) {
+		o.Transport.ServerName = serverName
+		o.Transport.configured = true
+	}
+}
+
+// WithCertificatePins 证书锁定，pin为证书公钥(SPKI)的sha256，支持base64或hex编码，可带"sha256/"前缀。
+// 校验证书时，验证通过的证书链(服务端证书、中间证书或根证书)中任意一个匹配即通过，服务端附加但未参与验证的证书不会匹配；
+// 同时开启WithInsecureSkipVerify时只匹配服务端自身的证书
+func WithCertificatePins(pins ...string) Option {
+	return func(o *option) {
+		o.Transport.CertificatePins = append(o.Transport.CertificatePins, pins...)
+		o.Transport.configured = true
+	}
+}
+
+// WithInsecureSkipVerify 跳过服务端证书校验，仅用于测试环境
+func WithInsecureSkipVerify(skip bool) Option {
+	return func(o *option) {
+		o.Transport.InsecureSkipVerify = skip
+		o.Transport.configured = true
+	}
+}
+
+func WithMaxIdleConns(maxIdleConns int) Option {
+	return func(o *option) {
+		o.Transport.MaxIdleConns = maxIdleConns
+		o.Transport.configured = true
+	}
+}
+
+func WithMaxIdleConnsPerHost(maxIdleConnsPerHost int) Option {
+	return func(o *option) {
+		o.Transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		o.Transport.configured = true
+	}
+}
+
+func WithIdleConnTimeout(idleConnTimeout time.Duration) Option {
+	return func(o *option) {
+		o.Transport.IdleConnTimeout = idleConnTimeout
+		o.Transport.configured = true
+	}
+}
+
+// CertificatePin 计算证书的pin值，格式为"sha256/<base64>"
+func CertificatePin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func decodePin(pin string) ([]byte, error) {
+	pin = strings.TrimPrefix(strings.TrimSpace(pin), pinPrefix)
+	if b, err := hex.DecodeString(pin); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(pin); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	return nil, fmt.Errorf("es: invalid certificate pin %q", pin)
+}
+
+// tlsConfig 根据Option构造tls.Config，WithTLSConfig传入的配置作为基础配置
+func (o *option) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.TLS != nil {
+		cfg = o.TLS.Clone()
+	}
+	t := o.Transport
+	if t.ServerName != "" {
+		cfg.ServerName = t.ServerName
+	}
+	if t.InsecureSkipVerify {
+		cfg.InsecureSkipVerify = true
+	}
+	if len(t.CAFiles) > 0 || len(t.CAPEMs) > 0 {
+		pool := x509.NewCertPool()
+		for _, file := range t.CAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("es: no certificate found in %s", file)
+			}
+		}
+		for _, pem := range t.CAPEMs {
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("es: no certificate found in CA PEM")
+			}
+		}
+		cfg.RootCAs = pool
+	}
+	if t.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.ClientCertFile, t.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	cfg.Certificates = append(cfg.Certificates, t.ClientCertificates...)
+	if len(t.CertificatePins) > 0 {
+		pins := make([][]byte, 0, len(t.CertificatePins))
+		for _, pin := range t.CertificatePins {
+			b, err := decodePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, b)
+		}
+		cfg.VerifyConnection = verifyPins(pins, cfg.InsecureSkipVerify)
+	}
+	return cfg, nil
+}
+
+// verifyPins 校验证书时只匹配验证通过的证书链，服务端附加在链上的其他证书不参与匹配；
+// 跳过校验时只匹配服务端自身的证书
+func verifyPins(pins [][]byte, insecure bool) func(tls.ConnectionState) error {
+	match := func(cert *x509.Certificate) bool {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if string(sum[:]) == string(pin) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(cs tls.ConnectionState) error {
+		if insecure {
+			if len(cs.PeerCertificates) > 0 && match(cs.PeerCertificates[0]) {
+				return nil
+			}
+			return errors.New("es: server certificate does not match any pin")
+		}
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				if match(cert) {
+					return nil
+				}
+			}
+		}
+		return errors.New("es: server certificate does not match any pin")
+	}
+}
+
+// httpClient 构造带连接池的http.Client，替代原先每次请求都新建连接的实现
+func (o *option) httpClient() (*http.Client, error) {
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := o.Transport
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConns:          defaultMaxIdleConns,
+		MaxIdleConnsPerHost:   defaultMaxIdleConnsPerHost,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+	}
+	if t.MaxIdleConns > 0 {
+		tr.MaxIdleConns = t.MaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+	if t.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = t.IdleConnTimeout
+	}
+	return &http.Client{Transport: tr}, nil
+}
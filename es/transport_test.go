@@ -0,0 +1,192 @@
+package es
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTLSServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"cluster_name":"test","status":"green"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func serverCAPEM(srv *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func doGet(t *testing.T, url string, options ...Option) error {
+	opt := &option{}
+	for _, f := range options {
+		f(opt)
+	}
+	client, err := opt.httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTransportVerifiesServerCertificate(t *testing.T) {
+	srv := newTLSServer(t)
+	if err := doGet(t, srv.URL); err == nil {
+		t.Fatal("expected unknown authority error without CA")
+	}
+	if err := doGet(t, srv.URL, WithCACertPEM(serverCAPEM(srv))); err != nil {
+		t.Fatal(err)
+	}
+	if err := doGet(t, srv.URL, WithInsecureSkipVerify(true)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportServerName(t *testing.T) {
+	srv := newTLSServer(t)
+	if err := doGet(t, srv.URL, WithCACertPEM(serverCAPEM(srv)), WithServerName("example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := doGet(t, srv.URL, WithCACertPEM(serverCAPEM(srv)), WithServerName("es.internal")); err == nil {
+		t.Fatal("expected hostname mismatch error")
+	}
+}
+
+func TestTransportCertificatePins(t *testing.T) {
+	srv := newTLSServer(t)
+	pin := CertificatePin(srv.Certificate())
+	if err := doGet(t, srv.URL, WithCACertPEM(serverCAPEM(srv)), WithCertificatePins(pin)); err != nil {
+		t.Fatal(err)
+	}
+	other := "sha256/" + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	if err := doGet(t, srv.URL, WithInsecureSkipVerify(true), WithCertificatePins(other)); err == nil {
+		t.Fatal("expected pin mismatch error")
+	}
+}
+
+// newTestCert 使用parent签发证书，parent为nil时自签名
+func newTestCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func TestTransportPinIgnoresUnverifiedChainEntries(t *testing.T) {
+	caTmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test-ca"}, IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true}
+	ca, caKey := newTestCert(t, caTmpl, nil, nil)
+	leaf, leafKey := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "es"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	//服务端没有该证书的私钥，只是把它附加在证书链上
+	pinned, _ := newTestCert(t, &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "pinned"}}, nil, nil)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw, pinned.Raw}, PrivateKey: leafKey}}}
+	srv.StartTLS()
+	defer srv.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+
+	if err := doGet(t, srv.URL, WithCACertPEM(caPEM), WithCertificatePins(CertificatePin(pinned))); err == nil {
+		t.Fatal("expected pin on unverified chain entry to be rejected")
+	}
+	if err := doGet(t, srv.URL, WithInsecureSkipVerify(true), WithCertificatePins(CertificatePin(pinned))); err == nil {
+		t.Fatal("expected pin on non-leaf certificate to be rejected without verification")
+	}
+	if err := doGet(t, srv.URL, WithCACertPEM(caPEM), WithCertificatePins(CertificatePin(ca))); err != nil {
+		t.Fatal(err)
+	}
+	if err := doGet(t, srv.URL, WithInsecureSkipVerify(true), WithCertificatePins(CertificatePin(leaf))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransportClientCertificate(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "es-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTmpl, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	if err = doGet(t, srv.URL, WithCACertPEM(serverCAPEM(srv))); err == nil {
+		t.Fatal("expected handshake failure without client certificate")
+	}
+	clientCert := tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+	if err = doGet(t, srv.URL, WithCACertPEM(serverCAPEM(srv)), WithClientCertificate(clientCert)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitClientWithTLSOptions(t *testing.T) {
+	srv := newTLSServer(t)
+	name := "tls-test-client"
+	err := InitClientWithOptions(name, []string{srv.URL}, "", "", WithCACertPEM(serverCAPEM(srv)), WithMaxIdleConnsPerHost(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer RemoveClient(name)
+	res, err := MustGetClient(name).Client.ClusterHealth().Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != "green" {
+		t.Fatalf("unexpected cluster health %+v", res)
+	}
+}
@@ -0,0 +1,248 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/olivere/elastic/v7"
+)
+
+// Searcher Client和RWClient都实现了该接口
+type Searcher interface {
+	Query(ctx context.Context, indexName string, routes []string, query elastic.Query, from, size int, options ...QueryOption) (*elastic.SearchResult, error)
+}
+
+type Getter interface {
+	Get(ctx context.Context, indexName, id, routing string) (*elastic.GetResult, error)
+}
+
+type MultiGetter interface {
+	MultiGet(ctx context.Context, items []Mget, options ...QueryOption) ([]*elastic.GetResult, error)
+}
+
+type Scroller interface {
+	ScrollQuery(ctx context.Context, index []string, typeStr string, query elastic.Query, size int, routes []string, callback func(res *elastic.SearchResult, err error), options ...QueryOption)
+}
+
+type CursorSearcher interface {
+	QueryAfter(ctx context.Context, indexName string, routes []string, query elastic.Query, cursor string, size int, options ...QueryOption) (*CursorPage, error)
+}
+
+// TotalHits 命中总数，Relation为eq表示精确值，gte表示下限
+type TotalHits struct {
+	Value    int64
+	Relation string
+}
+
+// Hit 解码后的文档，Version、SeqNo、PrimaryTerm需要通过WithVersion、WithSeqNoPrimaryTerm开启
+type Hit[T any] struct {
+	Index       string
+	ID          string
+	Score       *float64
+	Routing     string
+	Version     *int64
+	SeqNo       *int64
+	PrimaryTerm *int64
+	Sort        []interface{}
+	Highlight   map[string][]string
+	Source      T
+}
+
+type SearchResult[T any] struct {
+	Total        TotalHits
+	TookInMillis int64
+	MaxScore     *float64
+	Hits         []*Hit[T]
+	Aggs         AggResult
+	Raw          *elastic.SearchResult
+}
+
+// Docs 只返回文档内容
+func (r *SearchResult[T]) Docs() []T {
+	docs := make([]T, 0, len(r.Hits))
+	for _, hit := range r.Hits {
+		docs = append(docs, hit.Source)
+	}
+	return docs
+}
+
+type GetResult[T any] struct {
+	Index       string
+	ID          string
+	Routing     string
+	Found       bool
+	Version     *int64
+	SeqNo       *int64
+	PrimaryTerm *int64
+	Source      T
+	Error       *elastic.ErrorDetails //MultiGet中单个文档的错误
+}
+
+// DecodeHit 将_source解码为T，未返回_source时Source为零值
+func DecodeHit[T any](hit *elastic.SearchHit) (*Hit[T], error) {
+	h := &Hit[T]{
+		Index:       hit.Index,
+		ID:          hit.Id,
+		Score:       hit.Score,
+		Routing:     hit.Routing,
+		Version:     hit.Version,
+		SeqNo:       hit.SeqNo,
+		PrimaryTerm: hit.PrimaryTerm,
+		Sort:        hit.Sort,
+		Highlight:   hit.Highlight,
+	}
+	if len(hit.Source) > 0 {
+		if err := json.Unmarshal(hit.Source, &h.Source); err != nil {
+			return nil, fmt.Errorf("decode hit %s/%s: %w", hit.Index, hit.Id, err)
+		}
+	}
+	return h, nil
+}
+
+func DecodeSearchResult[T any](res *elastic.SearchResult) (*SearchResult[T], error) {
+	typed := &SearchResult[T]{Raw: res, Hits: make([]*Hit[T], 0)}
+	if res == nil {
+		return typed, nil
+	}
+	typed.TookInMillis = res.TookInMillis
+	if len(res.Aggregations) > 0 {
+		aggs, err := DecodeAggregations(res)
+		if err != nil {
+			return nil, err
+		}
+		typed.Aggs = aggs
+	}
+	if res.Hits == nil {
+		return typed, nil
+	}
+	if res.Hits.TotalHits != nil {
+		typed.Total = TotalHits{Value: res.Hits.TotalHits.Value, Relation: res.Hits.TotalHits.Relation}
+	}
+	typed.MaxScore = res.Hits.MaxScore
+	for _, hit := range res.Hits.Hits {
+		h, err := DecodeHit[T](hit)
+		if err != nil {
+			return nil, err
+		}
+		typed.Hits = append(typed.Hits, h)
+	}
+	return typed, nil
+}
+
+func DecodeGetResult[T any](res *elastic.GetResult) (*GetResult[T], error) {
+	typed := &GetResult[T]{
+		Index:       res.Index,
+		ID:          res.Id,
+		Routing:     res.Routing,
+		Found:       res.Found,
+		Version:     res.Version,
+		SeqNo:       res.SeqNo,
+		PrimaryTerm: res.PrimaryTerm,
+		Error:       res.Error,
+	}
+	if len(res.Source) > 0 {
+		if err := json.Unmarshal(res.Source, &typed.Source); err != nil {
+			return nil, fmt.Errorf("decode doc %s/%s: %w", res.Index, res.Id, err)
+		}
+	}
+	return typed, nil
+}
+
+// SearchAs 执行Query并将结果解码为T
+func SearchAs[T any](ctx context.Context, s Searcher, indexName string, routes []string, query elastic.Query, from, size int, options ...QueryOption) (*SearchResult[T], error) {
+	res, err := s.Query(ctx, indexName, routes, query, from, size, options...)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeSearchResult[T](res)
+}
+
+// GetAs 执行Get并将结果解码为T，文档不存在时返回elastic的404错误，可通过elastic.IsNotFound判断
+func GetAs[T any](ctx context.Context, g Getter, indexName, id, routing string) (*GetResult[T], error) {
+	res, err := g.Get(ctx, indexName, id, routing)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeGetResult[T](res)
+}
+
+// MultiGetAs 执行MultiGet并将结果解码为T，结果与items一一对应
+func MultiGetAs[T any](ctx context.Context, g MultiGetter, items []Mget, options ...QueryOption) ([]*GetResult[T], error) {
+	res, err := g.MultiGet(ctx, items, options...)
+	if err != nil {
+		return nil, err
+	}
+	typed := make([]*GetResult[T], 0, len(res))
+	for _, doc := range res {
+		result, err := DecodeGetResult[T](doc)
+		if err != nil {
+			return nil, err
+		}
+		typed = append(typed, result)
+	}
+	return typed, nil
+}
+
+// ScrollQueryAs 执行ScrollQuery，每一批结果解码为T后回调
+func ScrollQueryAs[T any](ctx context.Context, s Scroller, index []string, query elastic.Query, size int, routes []string, callback func(res *SearchResult[T], err error), options ...QueryOption) {
+	s.ScrollQuery(ctx, index, "", query, size, routes, func(res *elastic.SearchResult, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+		typed, err := DecodeSearchResult[T](res)
+		callback(typed, err)
+	}, options...)
+}
+
+type TypedCursorPage[T any] struct {
+	*SearchResult[T]
+	Cursor string
+}
+
+// QueryAfterAs 执行QueryAfter并将结果解码为T
+func QueryAfterAs[T any](ctx context.Context, s CursorSearcher, indexName string, routes []string, query elastic.Query, cursor string, size int, options ...QueryOption) (*TypedCursorPage[T], error) {
+	page, err := s.QueryAfter(ctx, indexName, routes, query, cursor, size, options...)
+	if err != nil {
+		return nil, err
+	}
+	typed, err := DecodeSearchResult[T](page.Result)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedCursorPage[T]{SearchResult: typed, Cursor: page.Cursor}, nil
+}
+
+// TypedScrollIterator 将ScrollIterator的每条文档解码为T，解码失败会停止遍历并通过Err返回
+type TypedScrollIterator[T any] struct {
+	*ScrollIterator
+	doc *Hit[T]
+	err error
+}
+
+func ScrollAs[T any](it *ScrollIterator) *TypedScrollIterator[T] {
+	return &TypedScrollIterator[T]{ScrollIterator: it}
+}
+
+func (it *TypedScrollIterator[T]) Next() bool {
+	if it.err != nil || !it.ScrollIterator.Next() {
+		return false
+	}
+	it.doc, it.err = DecodeHit[T](it.ScrollIterator.Doc())
+	if it.err != nil {
+		it.Close()
+		return false
+	}
+	return true
+}
+
+func (it *TypedScrollIterator[T]) Doc() *Hit[T] {
+	return it.doc
+}
+
+func (it *TypedScrollIterator[T]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.ScrollIterator.Err()
+}
@@ -0,0 +1,43 @@
+package es
+
+import (
+	"encoding/json"
+	"github.com/olivere/elastic/v7"
+	"testing"
+)
+
+type testDoc struct {
+	Title string `json:"title"`
+	Count int    `json:"count"`
+}
+
+func TestDecodeSearchResult(t *testing.T) {
+	raw := `{"took":3,"hits":{"total":{"value":10000,"relation":"gte"},"max_score":1.5,"hits":[
+		{"_index":"idx","_id":"1","_score":1.5,"_routing":"r1","_version":2,"_seq_no":7,"_primary_term":1,
+		 "_source":{"title":"hello","count":3},"highlight":{"title":["<em>hello</em>"]}},
+		{"_index":"idx","_id":"2","_score":0.5}]}}`
+	res := &elastic.SearchResult{}
+	if err := json.Unmarshal([]byte(raw), res); err != nil {
+		t.Fatal(err)
+	}
+	typed, err := DecodeSearchResult[testDoc](res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typed.Total.Value != 10000 || typed.Total.Relation != "gte" || len(typed.Hits) != 2 {
+		t.Fatalf("unexpected result %+v", typed)
+	}
+	h := typed.Hits[0]
+	if h.ID != "1" || h.Routing != "r1" || *h.Version != 2 || *h.SeqNo != 7 || h.Source.Title != "hello" ||
+		h.Source.Count != 3 || h.Highlight["title"][0] != "<em>hello</em>" {
+		t.Fatalf("unexpected hit %+v", h)
+	}
+	if typed.Hits[1].Source != (testDoc{}) {
+		t.Fatalf("expected zero source, got %+v", typed.Hits[1].Source)
+	}
+
+	res.Hits.Hits[1].Source = json.RawMessage(`{"count":"x"}`)
+	if _, err = DecodeSearchResult[testDoc](res); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
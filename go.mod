@@ -6,7 +6,7 @@ require (
 	github.com/olivere/elastic/v7 v7.0.32
 	github.com/pkg/errors v0.9.1
 	go.uber.org/zap v1.24.0
-	golang.org/x/net v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
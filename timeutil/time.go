@@ -14,6 +14,7 @@ var (
 // CSTLayout China Standard Time Layout
 const CSTLayout = "2006-01-02 15:04:05"
 const YMDLayout = "20060102"
+const YMLayout = "200601"
 
 func init() {
 	var err error
@@ -74,6 +75,39 @@ func YMDLayoutString(t time.Time) string {
 	return t.In(cst).Format(YMDLayout)
 }
 
+func YMLayoutString(t time.Time) string {
+	return t.In(cst).Format(YMLayout)
+}
+
+// ParseYMDInLocation 解析 "20060102" 格式的日期
+func ParseYMDInLocation(date string) (time.Time, error) {
+	return time.ParseInLocation(YMDLayout, date, cst)
+}
+
+// ParseYMInLocation 解析 "200601" 格式的月份
+func ParseYMInLocation(date string) (time.Time, error) {
+	return time.ParseInLocation(YMLayout, date, cst)
+}
+
+// StartOfDay 当天0点
+func StartOfDay(t time.Time) time.Time {
+	t = t.In(cst)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, cst)
+}
+
+// StartOfWeek 所在周的周一0点
+func StartOfWeek(t time.Time) time.Time {
+	day := StartOfDay(t)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// StartOfMonth 当月1号0点
+func StartOfMonth(t time.Time) time.Time {
+	t = t.In(cst)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, cst)
+}
+
 // SubInLocation 计算时间差
 func SubInLocation(ts time.Time) float64 {
 	return math.Abs(time.Now().In(cst).Sub(ts).Seconds())
@@ -25,3 +25,13 @@ func TestYMDLayoutInt64(t *testing.T) {
 	tm := YMDLayoutInt64(time.Now())
 	t.Log(tm)
 }
+
+func TestStartOfWeek(t *testing.T) {
+	ts, _ := ParseYMDInLocation("20261018")
+	if week := YMDLayoutString(StartOfWeek(ts)); week != "20261012" {
+		t.Fatalf("unexpected start of week %s", week)
+	}
+	if month := YMDLayoutString(StartOfMonth(ts)); month != "20261001" {
+		t.Fatalf("unexpected start of month %s", month)
+	}
+}